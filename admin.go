@@ -0,0 +1,229 @@
+package xds
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+const (
+	adminMaxRequestBodySize int64 = 8 * 1024 * 1024
+)
+
+type adminHandler struct {
+	mux      *http.ServeMux
+	registry *proxyRegistry
+	watch    *WatchFile
+	write    bool
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *adminHandler) proxyStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.registry.Proxies())
+}
+
+// snapshot returns the current resources, ?node-group=<name> filters a node group
+func (h *adminHandler) snapshot(w http.ResponseWriter, r *http.Request) {
+	states, err := h.watch.SnapshotStates()
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	name := r.URL.Query().Get("node-group")
+	if name == "" {
+		writeJSON(w, http.StatusOK, states)
+		return
+	}
+	for _, state := range states {
+		if state.NodeGroup == name {
+			writeJSON(w, http.StatusOK, state)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "node group not found: " + name})
+}
+
+// config returns the parsed config files and the last reload error of each file, ?node-group=<name> filters a node group
+func (h *adminHandler) config(w http.ResponseWriter, r *http.Request) {
+	states := h.watch.ConfigStates()
+
+	name := r.URL.Query().Get("node-group")
+	if name == "" {
+		writeJSON(w, http.StatusOK, states)
+		return
+	}
+	for _, state := range states {
+		if state.NodeGroup == name {
+			writeJSON(w, http.StatusOK, state)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "node group not found: " + name})
+}
+
+// watchStatus returns the state of config file watching, 503 while it is lost
+func (h *adminHandler) watchStatus(w http.ResponseWriter, r *http.Request) {
+	st := h.watch.WatchStatus()
+	if st.Status == WatchStatusLost {
+		writeJSON(w, http.StatusServiceUnavailable, st)
+		return
+	}
+	writeJSON(w, http.StatusOK, st)
+}
+
+// history returns the applied snapshots, ?node-group=<name> filters a node group
+func (h *adminHandler) history(w http.ResponseWriter, r *http.Request) {
+	list := h.watch.History()
+
+	name := r.URL.Query().Get("node-group")
+	if name == "" {
+		writeJSON(w, http.StatusOK, list)
+		return
+	}
+	for _, history := range list {
+		if history.NodeGroup == name {
+			writeJSON(w, http.StatusOK, history)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "node group not found: " + name})
+}
+
+// historyRollback applies ?id=<id> of ?node-group=<name>
+func (h *adminHandler) historyRollback(w http.ResponseWriter, r *http.Request) {
+	h.applyHistory(w, r, h.watch.RollbackHistory)
+}
+
+// historyPin applies ?id=<id> of ?node-group=<name> and ignores file changes until unpin
+func (h *adminHandler) historyPin(w http.ResponseWriter, r *http.Request) {
+	h.applyHistory(w, r, h.watch.PinHistory)
+}
+
+// historyUnpin reloads the files of ?node-group=<name>
+func (h *adminHandler) historyUnpin(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("node-group")
+	if err := h.watch.Unpin(name); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.writeHistory(w, name)
+}
+
+func (h *adminHandler) applyHistory(w http.ResponseWriter, r *http.Request, fn func(string, uint64) error) {
+	name := r.URL.Query().Get("node-group")
+	id, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid history id: " + err.Error()})
+		return
+	}
+	if err := fn(name, id); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	h.writeHistory(w, name)
+}
+
+func (h *adminHandler) writeHistory(w http.ResponseWriter, name string) {
+	for _, history := range h.watch.History() {
+		if history.NodeGroup == name {
+			writeJSON(w, http.StatusOK, history)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "node group not found: " + name})
+}
+
+// diff returns the resource diff between the published snapshot of ?node-group=<name> (default if empty)
+// and the proposed yaml contents in the json body {"cds": "...", "eds": "...", "rds": "...", "lds": "...", "runtime": "..."}
+func (h *adminHandler) diff(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("node-group")
+	if name == "" {
+		name = DefaultNodeGroupName
+	}
+
+	proposed := ConfigContents{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminMaxRequestBodySize)).Decode(&proposed); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request body: " + err.Error()})
+		return
+	}
+
+	d, err := h.watch.DiffContents(name, proposed)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, d)
+}
+
+func (h *adminHandler) registerHandlers() {
+	h.mux.HandleFunc("/proxy-status", readOnly(h.proxyStatus))
+	h.mux.Handle("/metrics", metricsHandler())
+	if h.watch != nil {
+		h.mux.HandleFunc("/snapshot", readOnly(h.snapshot))
+		h.mux.HandleFunc("/config", readOnly(h.config))
+		h.mux.HandleFunc("/watch", readOnly(h.watchStatus))
+		h.mux.HandleFunc("/history", readOnly(h.history))
+		h.mux.HandleFunc("/history/rollback", h.writable(postOnly(h.historyRollback)))
+		h.mux.HandleFunc("/history/pin", h.writable(postOnly(h.historyPin)))
+		h.mux.HandleFunc("/history/unpin", h.writable(postOnly(h.historyUnpin)))
+		h.mux.HandleFunc("/diff", h.writable(postOnly(h.diff)))
+	}
+}
+
+// writable rejects fn unless AdminWriteEnable
+func (h *adminHandler) writable(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.write != true {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "admin write api is disabled"})
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func newAdminHandler(registry *proxyRegistry, watch *WatchFile, write bool) *adminHandler {
+	h := &adminHandler{
+		mux:      http.NewServeMux(),
+		registry: registry,
+		watch:    watch,
+		write:    write,
+	}
+	h.registerHandlers()
+	return h
+}
+
+func readOnly(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func postOnly(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		fn(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("warn: admin response write error: %s", err.Error())
+	}
+}
@@ -0,0 +1,44 @@
+package xds
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminWrite(t *testing.T) {
+	w := NewWatchFile(context.Background())
+	paths := []string{
+		"/history/rollback?node-group=default&id=1",
+		"/history/pin?node-group=default&id=1",
+		"/history/unpin?node-group=default",
+		"/diff?node-group=default", // invalid body
+	}
+
+	tests := []struct {
+		name   string
+		write  bool
+		expect int
+	}{
+		{"disabled", false, http.StatusForbidden},
+		{"enabled", true, http.StatusBadRequest}, // history #1 not found, invalid diff body
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(tc *testing.T) {
+			h := newAdminHandler(nil, w, tt.write)
+			for _, path := range paths {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+				if rec.Code != tt.expect {
+					tc.Errorf("%s: expect status %d actual %d %s", path, tt.expect, rec.Code, rec.Body.String())
+				}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/history", nil))
+			if rec.Code != http.StatusOK {
+				tc.Errorf("read only api must be served: %d", rec.Code)
+			}
+		})
+	}
+}
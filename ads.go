@@ -0,0 +1,88 @@
+package xds
+
+import (
+	typesv3 "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+const (
+	adsWarmingVersionPrefix string = "warming-"
+)
+
+// warmingStages returns the make-before-break snapshots published before next for ADS ordering.
+//  1. new clusters and endpoints are added to the current ones while the other types keep the current version,
+//     so that CDS/EDS are delivered before LDS/RDS refer them. omitted if next does not add any.
+//  2. listeners, routes and the others are switched to next while the removed clusters and endpoints are kept,
+//     so that they are pruned after nothing refers them. omitted if next does not remove any.
+//
+// the clusters and endpoints of the stages have the version of next unless some of them are removed,
+// envoy does not receive the same set twice
+func warmingStages(current cachev3.ResourceSnapshot, next *cachev3.Snapshot) []*cachev3.Snapshot {
+	added := diffResourceNames(next, current, resourcev3.ClusterType) || diffResourceNames(next, current, resourcev3.EndpointType)
+	removed := diffResourceNames(current, next, resourcev3.ClusterType) || diffResourceNames(current, next, resourcev3.EndpointType)
+
+	stages := make([]*cachev3.Snapshot, 0, 2)
+	if added {
+		stages = append(stages, warmingSnapshot(current, next, removed, current))
+	}
+	if removed {
+		stages = append(stages, warmingSnapshot(current, next, removed, next))
+	}
+	return stages
+}
+
+// warmingSnapshot merges the clusters and endpoints of current and next, the other types are copied from others
+func warmingSnapshot(current cachev3.ResourceSnapshot, next *cachev3.Snapshot, removed bool, others cachev3.ResourceSnapshot) *cachev3.Snapshot {
+	warming := new(cachev3.Snapshot)
+	for i := range next.Resources {
+		typeURL, err := cachev3.GetResponseTypeURL(typesv3.ResponseType(i))
+		if err != nil {
+			continue
+		}
+
+		switch typeURL {
+		case resourcev3.ClusterType, resourcev3.EndpointType:
+			version := next.GetVersion(typeURL)
+			if removed {
+				version = adsWarmingVersionPrefix + version
+			}
+			warming.Resources[i] = mergeResources(
+				version,
+				current.GetResourcesAndTTL(typeURL),
+				next.GetResourcesAndTTL(typeURL),
+			)
+		default:
+			warming.Resources[i] = cachev3.Resources{
+				Version: others.GetVersion(typeURL),
+				Items:   others.GetResourcesAndTTL(typeURL),
+			}
+		}
+	}
+	return warming
+}
+
+// diffResourceNames reports whether a has a resource of typeURL that b does not have
+func diffResourceNames(a, b cachev3.ResourceSnapshot, typeURL resourcev3.Type) bool {
+	bItems := b.GetResourcesAndTTL(typeURL)
+	for name := range a.GetResourcesAndTTL(typeURL) {
+		if _, ok := bItems[name]; ok != true {
+			return true
+		}
+	}
+	return false
+}
+
+func mergeResources(version string, current, next map[string]typesv3.ResourceWithTTL) cachev3.Resources {
+	items := make(map[string]typesv3.ResourceWithTTL, len(current)+len(next))
+	for name, r := range current {
+		items[name] = r
+	}
+	for name, r := range next {
+		items[name] = r
+	}
+	return cachev3.Resources{
+		Version: version,
+		Items:   items,
+	}
+}
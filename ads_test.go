@@ -0,0 +1,75 @@
+package xds
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	typesv3 "github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestWarmingStages(t *testing.T) {
+	snapshot := func(version string, clusters ...string) *cachev3.Snapshot {
+		cds := make([]typesv3.Resource, 0, len(clusters))
+		for _, name := range clusters {
+			cds = append(cds, &clusterv3.Cluster{Name: name})
+		}
+		s, err := cachev3.NewSnapshot(version, map[resourcev3.Type][]typesv3.Resource{
+			resourcev3.ClusterType: cds,
+			resourcev3.RouteType:   {&routev3.RouteConfiguration{Name: "route-" + version}},
+		})
+		if err != nil {
+			t.Fatalf("snapshot: %s", err.Error())
+		}
+		return s
+	}
+	names := func(s *cachev3.Snapshot, typeURL resourcev3.Type) string {
+		list := make([]string, 0)
+		for name := range s.GetResourcesAndTTL(typeURL) {
+			list = append(list, name)
+		}
+		sort.Strings(list)
+		return strings.Join(list, ",")
+	}
+	type stage struct {
+		cdsVersion string
+		clusters   string
+		rdsVersion string
+	}
+	tests := []struct {
+		name   string
+		next   *cachev3.Snapshot
+		expect []stage
+	}{
+		{"unchanged names", snapshot("v2", "a", "b"), []stage{}},
+		{"add", snapshot("v2", "a", "b", "c"), []stage{
+			{"v2", "a,b,c", "v1"},
+		}},
+		{"remove", snapshot("v2", "a"), []stage{
+			{"warming-v2", "a,b", "v2"},
+		}},
+		{"replace", snapshot("v2", "a", "c"), []stage{
+			{"warming-v2", "a,b,c", "v1"},
+			{"warming-v2", "a,b,c", "v2"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(tc *testing.T) {
+			stages := warmingStages(snapshot("v1", "a", "b"), tt.next)
+			if len(stages) != len(tt.expect) {
+				tc.Fatalf("expect %d stage(s) actual %d", len(tt.expect), len(stages))
+			}
+			for i, e := range tt.expect {
+				s := stages[i]
+				actual := stage{s.GetVersion(resourcev3.ClusterType), names(s, resourcev3.ClusterType), s.GetVersion(resourcev3.RouteType)}
+				if actual != e {
+					tc.Errorf("stage #%d: expect %+v actual %+v", i, e, actual)
+				}
+			}
+		})
+	}
+}
@@ -88,6 +88,8 @@ func (h *accesslogServiceHandler) StreamAccessLogs(stream alsv3.AccessLogService
 	// https://godoc.org/github.com/envoyproxy/go-control-plane/envoy/data/accesslog/v3#AccessLogCommon
 	logId := msg.GetIdentifier().GetLogName()
 	entries := msg.GetHttpLogs().GetLogEntry()
+	metricAlsMessagesTotal.WithLabelValues(logId).Inc()
+	metricAlsEntriesTotal.WithLabelValues(logId).Add(float64(len(entries)))
 	logs := make([]AccessLog, len(entries))
 	for i, httplog := range entries {
 		props := httplog.GetCommonProperties()
@@ -122,7 +124,9 @@ func (h *accesslogServiceHandler) StreamAccessLogs(stream alsv3.AccessLogService
 	for _, acclog := range logs {
 		buf := acclogBufPool.Get()
 		acclog.WriteTo(logId, buf)
-		h.log.Writer().Write(buf.Bytes())
+		if _, err := h.log.Writer().Write(buf.Bytes()); err != nil {
+			metricAlsDroppedEntriesTotal.WithLabelValues(logId).Inc()
+		}
 		acclogBufPool.Put(buf)
 	}
 
@@ -0,0 +1,419 @@
+package xds
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"gopkg.in/yaml.v2"
+
+	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	upstreamhttpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+const (
+	BootstrapFormatYAML string = "yaml"
+	BootstrapFormatJSON string = "json"
+)
+
+const (
+	defaultBootstrapXdsAddr        string        = "127.0.0.1:8000"
+	defaultBootstrapAlsAddr        string        = "127.0.0.1:8001"
+	defaultBootstrapConnectTimeout time.Duration = 1 * time.Second
+	bootstrapHealthCheckTimeout    time.Duration = 1 * time.Second
+	bootstrapHealthCheckInterval   time.Duration = 5 * time.Second
+	bootstrapAdminLayerName        string        = "admin"
+	upstreamHttpProtocolOptions    string        = "envoy.extensions.upstreams.http.v3.HttpProtocolOptions"
+)
+
+type bootstrapOptFunc func(*bootstrapOpt)
+
+type bootstrapOpt struct {
+	nodeId         string
+	cluster        string
+	region         string
+	zone           string
+	xdsAddr        string
+	alsAddr        string
+	backupXdsAddrs []string
+	adminAddr      string
+	ads            bool
+	delta          bool
+	tlsCAFile      string
+	tlsCertFile    string
+	tlsKeyFile     string
+	tlsSNI         string
+	runtimeLayers  []string
+}
+
+func BootstrapNode(nodeId, cluster string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.nodeId = nodeId
+		opt.cluster = cluster
+	}
+}
+
+func BootstrapLocality(region, zone string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.region = region
+		opt.zone = zone
+	}
+}
+
+// BootstrapXdsAddr is the host:port of the xds server
+func BootstrapXdsAddr(addr string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.xdsAddr = addr
+	}
+}
+
+// BootstrapAlsAddr is the host:port of the als server
+func BootstrapAlsAddr(addr string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.alsAddr = addr
+	}
+}
+
+// BootstrapBackupXdsAddrs are the host:port of the backup xds servers,
+// used in order while the primary is unhealthy
+func BootstrapBackupXdsAddrs(addrs ...string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.backupXdsAddrs = addrs
+	}
+}
+
+// BootstrapAdminAddr enables envoy admin on host:port, disabled if empty
+func BootstrapAdminAddr(addr string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.adminAddr = addr
+	}
+}
+
+func BootstrapADS(enable bool) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.ads = enable
+	}
+}
+
+func BootstrapDelta(enable bool) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.delta = enable
+	}
+}
+
+// BootstrapTLS enables upstream TLS to xds and als servers verified by caFile,
+// certFile and keyFile are the client certificate (mTLS), optional
+func BootstrapTLS(caFile, certFile, keyFile, sni string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.tlsCAFile = caFile
+		opt.tlsCertFile = certFile
+		opt.tlsKeyFile = keyFile
+		opt.tlsSNI = sni
+	}
+}
+
+// BootstrapRuntimeLayers are the RTDS layers, disabled if empty
+func BootstrapRuntimeLayers(names ...string) bootstrapOptFunc {
+	return func(opt *bootstrapOpt) {
+		opt.runtimeLayers = names
+	}
+}
+
+func initBootstrapOpt(opt *bootstrapOpt) {
+	if len(opt.xdsAddr) < 1 {
+		opt.xdsAddr = defaultBootstrapXdsAddr
+	}
+	if len(opt.alsAddr) < 1 {
+		opt.alsAddr = defaultBootstrapAlsAddr
+	}
+	if opt.runtimeLayers == nil {
+		opt.runtimeLayers = []string{BootstrapRuntimeLayerName}
+	}
+}
+
+// NewBootstrap returns the envoy bootstrap that connects to this server,
+// cluster names and runtime layer match the ones referenced by the served resources
+func NewBootstrap(funcs ...bootstrapOptFunc) (*bootstrapv3.Bootstrap, error) {
+	opt := new(bootstrapOpt)
+	for _, fn := range funcs {
+		fn(opt)
+	}
+	initBootstrapOpt(opt)
+
+	if opt.nodeId == "" || opt.cluster == "" {
+		return nil, fmt.Errorf("node id and cluster are required")
+	}
+	if (opt.tlsCertFile == "") != (opt.tlsKeyFile == "") {
+		return nil, fmt.Errorf("both tls cert and tls key are required")
+	}
+	if opt.tlsCertFile != "" && opt.tlsCAFile == "" {
+		return nil, fmt.Errorf("tls cert requires tls ca")
+	}
+
+	xdsAddrs := append([]string{opt.xdsAddr}, opt.backupXdsAddrs...)
+	xdsCluster, err := bootstrapCluster(opt, BootstrapXdsClusterName, xdsAddrs...)
+	if err != nil {
+		return nil, err
+	}
+	alsCluster, err := bootstrapAlsCluster(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bootstrapv3.Bootstrap{
+		Node: &corev3.Node{
+			Id:      opt.nodeId,
+			Cluster: opt.cluster,
+			Locality: &corev3.Locality{
+				Region: opt.region,
+				Zone:   opt.zone,
+			},
+		},
+		StaticResources: &bootstrapv3.Bootstrap_StaticResources{
+			Clusters: []*clusterv3.Cluster{xdsCluster, alsCluster},
+		},
+		DynamicResources: bootstrapDynamicResources(opt),
+		LayeredRuntime:   bootstrapLayeredRuntime(opt),
+	}
+
+	if opt.adminAddr != "" {
+		address, err := socketAddress(opt.adminAddr)
+		if err != nil {
+			return nil, fmt.Errorf("admin address '%s': %s", opt.adminAddr, err.Error())
+		}
+		b.Admin = &bootstrapv3.Admin{Address: address}
+	}
+
+	if err := b.ValidateAll(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func bootstrapDynamicResources(opt *bootstrapOpt) *bootstrapv3.Bootstrap_DynamicResources {
+	apiType := corev3.ApiConfigSource_GRPC
+	if opt.delta {
+		apiType = corev3.ApiConfigSource_DELTA_GRPC
+	}
+
+	d := &bootstrapv3.Bootstrap_DynamicResources{
+		LdsConfig: xdsConfigSource(opt.ads, opt.delta),
+		CdsConfig: xdsConfigSource(opt.ads, opt.delta),
+	}
+	if opt.ads {
+		d.AdsConfig = xdsApiConfigSource(apiType).GetApiConfigSource()
+	}
+	return d
+}
+
+func bootstrapLayeredRuntime(opt *bootstrapOpt) *bootstrapv3.LayeredRuntime {
+	layers := make([]*bootstrapv3.RuntimeLayer, 0, len(opt.runtimeLayers)+1)
+	for _, name := range opt.runtimeLayers {
+		layers = append(layers, &bootstrapv3.RuntimeLayer{
+			Name: name,
+			LayerSpecifier: &bootstrapv3.RuntimeLayer_RtdsLayer_{
+				RtdsLayer: &bootstrapv3.RuntimeLayer_RtdsLayer{
+					Name:       name,
+					RtdsConfig: xdsConfigSource(opt.ads, opt.delta),
+				},
+			},
+		})
+	}
+	if opt.adminAddr != "" {
+		// runtime_modify on envoy admin requires an admin layer
+		layers = append(layers, &bootstrapv3.RuntimeLayer{
+			Name: bootstrapAdminLayerName,
+			LayerSpecifier: &bootstrapv3.RuntimeLayer_AdminLayer_{
+				AdminLayer: &bootstrapv3.RuntimeLayer_AdminLayer{},
+			},
+		})
+	}
+	if len(layers) < 1 {
+		return nil
+	}
+	return &bootstrapv3.LayeredRuntime{Layers: layers}
+}
+
+// bootstrapAlsCluster returns the cluster that the access log of the served listeners sends to
+func bootstrapAlsCluster(opt *bootstrapOpt) (*clusterv3.Cluster, error) {
+	c, err := bootstrapCluster(opt, BootstrapAlsClusterName, opt.alsAddr)
+	if err != nil {
+		return nil, err
+	}
+	c.UpstreamConnectionOptions = &clusterv3.UpstreamConnectionOptions{
+		TcpKeepalive: &corev3.TcpKeepalive{},
+	}
+	return c, nil
+}
+
+// bootstrapCluster returns the http2 cluster of addrs, addrs[n] is used at priority n
+func bootstrapCluster(opt *bootstrapOpt, name string, addrs ...string) (*clusterv3.Cluster, error) {
+	discoveryType := clusterv3.Cluster_STATIC
+	endpoints := make([]*endpointv3.LocalityLbEndpoints, len(addrs))
+	for i, addr := range addrs {
+		address, err := socketAddress(addr)
+		if err != nil {
+			return nil, fmt.Errorf("%s address '%s': %s", name, addr, err.Error())
+		}
+		if net.ParseIP(address.GetSocketAddress().GetAddress()) == nil {
+			discoveryType = clusterv3.Cluster_STRICT_DNS
+		}
+		endpoints[i] = &endpointv3.LocalityLbEndpoints{
+			Priority: uint32(i),
+			LbEndpoints: []*endpointv3.LbEndpoint{
+				&endpointv3.LbEndpoint{
+					HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+						Endpoint: &endpointv3.Endpoint{Address: address},
+					},
+				},
+			},
+		}
+	}
+
+	protocolOptions, err := ptypes.MarshalAny(&upstreamhttpv3.HttpProtocolOptions{
+		UpstreamProtocolOptions: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &upstreamhttpv3.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &corev3.Http2ProtocolOptions{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c := &clusterv3.Cluster{
+		Name:                 name,
+		ConnectTimeout:       ptypes.DurationProto(defaultBootstrapConnectTimeout),
+		ClusterDiscoveryType: &clusterv3.Cluster_Type{Type: discoveryType},
+		LbPolicy:             clusterv3.Cluster_ROUND_ROBIN,
+		TypedExtensionProtocolOptions: map[string]*anypb.Any{
+			upstreamHttpProtocolOptions: protocolOptions,
+		},
+		LoadAssignment: &endpointv3.ClusterLoadAssignment{
+			ClusterName: name,
+			Endpoints:   endpoints,
+		},
+	}
+
+	if 1 < len(addrs) {
+		// failover to the next priority requires the unhealthy host to be detected
+		c.HealthChecks = []*corev3.HealthCheck{
+			&corev3.HealthCheck{
+				Timeout:            ptypes.DurationProto(bootstrapHealthCheckTimeout),
+				Interval:           ptypes.DurationProto(bootstrapHealthCheckInterval),
+				UnhealthyThreshold: &wrappers.UInt32Value{Value: 1},
+				HealthyThreshold:   &wrappers.UInt32Value{Value: 1},
+				HealthChecker: &corev3.HealthCheck_TcpHealthCheck_{
+					TcpHealthCheck: &corev3.HealthCheck_TcpHealthCheck{},
+				},
+			},
+		}
+	}
+
+	if opt.tlsCAFile != "" {
+		transportSocket, err := bootstrapTransportSocket(opt)
+		if err != nil {
+			return nil, err
+		}
+		c.TransportSocket = transportSocket
+	}
+	return c, nil
+}
+
+func bootstrapTransportSocket(opt *bootstrapOpt) (*corev3.TransportSocket, error) {
+	common := &tlsv3.CommonTlsContext{
+		ValidationContextType: &tlsv3.CommonTlsContext_ValidationContext{
+			ValidationContext: &tlsv3.CertificateValidationContext{
+				TrustedCa: fileDataSource(opt.tlsCAFile),
+			},
+		},
+	}
+	if opt.tlsCertFile != "" {
+		common.TlsCertificates = []*tlsv3.TlsCertificate{
+			&tlsv3.TlsCertificate{
+				CertificateChain: fileDataSource(opt.tlsCertFile),
+				PrivateKey:       fileDataSource(opt.tlsKeyFile),
+			},
+		}
+	}
+
+	tlsContext, err := ptypes.MarshalAny(&tlsv3.UpstreamTlsContext{
+		Sni:              opt.tlsSNI,
+		CommonTlsContext: common,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &corev3.TransportSocket{
+		Name: wellknown.TransportSocketTls,
+		ConfigType: &corev3.TransportSocket_TypedConfig{
+			TypedConfig: tlsContext,
+		},
+	}, nil
+}
+
+func fileDataSource(file string) *corev3.DataSource {
+	return &corev3.DataSource{
+		Specifier: &corev3.DataSource_Filename{Filename: file},
+	}
+}
+
+func socketAddress(addr string) (*corev3.Address, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	portValue, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", port)
+	}
+	return &corev3.Address{
+		Address: &corev3.Address_SocketAddress{
+			SocketAddress: &corev3.SocketAddress{
+				Protocol: corev3.SocketAddress_TCP,
+				Address:  host,
+				PortSpecifier: &corev3.SocketAddress_PortValue{
+					PortValue: uint32(portValue),
+				},
+			},
+		},
+	}, nil
+}
+
+// MarshalBootstrap encodes b as BootstrapFormatYAML or BootstrapFormatJSON
+func MarshalBootstrap(b *bootstrapv3.Bootstrap, format string) ([]byte, error) {
+	data, err := protojson.MarshalOptions{Indent: "  ", UseProtoNames: true}.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertFormat(data, format)
+}
+
+// convertFormat converts the json data to format
+func convertFormat(data []byte, format string) ([]byte, error) {
+	switch format {
+	case BootstrapFormatJSON:
+		return append(data, '\n'), nil
+	case BootstrapFormatYAML:
+		// json is yaml, MapSlice keeps the field order
+		v := yaml.MapSlice{}
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	}
+	return nil, fmt.Errorf("unknown format '%s'", format)
+}
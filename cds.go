@@ -4,11 +4,11 @@ import (
 	"log"
 	"sort"
 	"strconv"
-	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/proto"
 
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
@@ -161,11 +161,6 @@ func initCdsOpt(opt *cdsOpt) {
 type clusterDiscoveryService struct {
 	opt       *cdsOpt
 	xdsConfig *corev3.ConfigSource
-	version   uint64
-}
-
-func (c *clusterDiscoveryService) increVersion() uint64 {
-	return atomic.AddUint64(&c.version, 1)
 }
 
 func (c *clusterDiscoveryService) commonLbConfig(cfg CDSConfig) *clusterv3.Cluster_CommonLbConfig {
@@ -358,8 +353,16 @@ func (c *clusterDiscoveryService) outlierDetection(cfg CDSConfig) *clusterv3.Out
 }
 
 func (c *clusterDiscoveryService) create(configs []CDSConfig) (string, []*clusterv3.Cluster, error) {
-	version := strconv.FormatUint(c.increVersion(), 10)
-	return version, c.clusters(configs), nil
+	clusters := c.clusters(configs)
+	resources := make([]proto.Message, len(clusters))
+	for i, cluster := range clusters {
+		resources[i] = cluster
+	}
+	version, err := resourceVersion(resources...)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, clusters, nil
 }
 
 func newClusterDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...cdsOptFunc) *clusterDiscoveryService {
@@ -372,6 +375,5 @@ func newClusterDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...cdsOptF
 	return &clusterDiscoveryService{
 		opt:       opt,
 		xdsConfig: xdsConfig,
-		version:   uint64(0),
 	}
 }
@@ -5,19 +5,19 @@ import (
 )
 
 type CDSConfig struct {
-	ClusterName string               `yaml:"name"         validate:"required"`
-	LbPolicy    string               `yaml:"lb-policy"    validate:"required"`
-	HealthCheck CDSHealthCheckConfig `yaml:"health-check" validate:"required"`
+	ClusterName string               `yaml:"name"         json:"name"         validate:"required"`
+	LbPolicy    string               `yaml:"lb-policy"    json:"lb-policy"    validate:"required"`
+	HealthCheck CDSHealthCheckConfig `yaml:"health-check" json:"health-check" validate:"required"`
 }
 
 type CDSHealthCheckConfig struct {
-	Host           string   `yaml:"host"         validate:""`
-	Path           string   `yaml:"path"         validate:"required"`
-	Status         []string `yaml:"status"       validate:"required,unique"`
-	Timeout        uint32   `yaml:"timeout"      validate:"gte=1,lte=900"`
-	Interval       uint32   `yaml:"interval"     validate:"gte=1,lte=180"`
-	HealthyCount   uint32   `yaml:"healthy"      validate:"gte=1,lte=10"`
-	UnhealthyCount uint32   `yaml:"unhealthy"    validate:"gte=1,lte=10"`
+	Host           string   `yaml:"host"         json:"host"         validate:""`
+	Path           string   `yaml:"path"         json:"path"         validate:"required"`
+	Status         []string `yaml:"status"       json:"status"       validate:"required,unique"`
+	Timeout        uint32   `yaml:"timeout"      json:"timeout"      validate:"gte=1,lte=900"`
+	Interval       uint32   `yaml:"interval"     json:"interval"     validate:"gte=1,lte=180"`
+	HealthyCount   uint32   `yaml:"healthy"      json:"healthy"      validate:"gte=1,lte=10"`
+	UnhealthyCount uint32   `yaml:"unhealthy"    json:"unhealthy"    validate:"gte=1,lte=10"`
 }
 
 func (c CDSHealthCheckConfig) TimeoutSecond() time.Duration {
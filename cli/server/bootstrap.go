@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func bootstrapAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	format := c.String("format")
+	if format != xds.BootstrapFormatYAML && format != xds.BootstrapFormatJSON {
+		return fmt.Errorf("--format must be %s or %s", xds.BootstrapFormatYAML, xds.BootstrapFormatJSON)
+	}
+
+	adminAddr := ""
+	if c.String("admin-port") != "" {
+		adminAddr = net.JoinHostPort(c.String("admin-host"), c.String("admin-port"))
+	}
+	runtimeLayers := c.StringSlice("rtds-layer")
+	if len(runtimeLayers) < 1 {
+		runtimeLayers = []string{xds.BootstrapRuntimeLayerName}
+	}
+	if c.Bool("no-rtds") {
+		runtimeLayers = []string{}
+	}
+
+	b, err := xds.NewBootstrap(
+		xds.BootstrapNode(c.String("node-id"), c.String("cluster")),
+		xds.BootstrapLocality(c.String("region"), c.String("zone")),
+		xds.BootstrapXdsAddr(net.JoinHostPort(c.String("xds-host"), c.String("xds-port"))),
+		xds.BootstrapAlsAddr(net.JoinHostPort(c.String("als-host"), c.String("als-port"))),
+		xds.BootstrapBackupXdsAddrs(c.StringSlice("backup-xds-addr")...),
+		xds.BootstrapAdminAddr(adminAddr),
+		xds.BootstrapADS(c.Bool("ads")),
+		xds.BootstrapDelta(c.Bool("delta")),
+		xds.BootstrapTLS(c.String("tls-ca"), c.String("tls-cert"), c.String("tls-key"), c.String("tls-sni")),
+		xds.BootstrapRuntimeLayers(runtimeLayers...),
+	)
+	if err != nil {
+		return err
+	}
+
+	data, err := xds.MarshalBootstrap(b, format)
+	if err != nil {
+		return err
+	}
+	if c.String("output") == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(c.String("output"), data, 0644)
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:   "bootstrap",
+		Usage:  "print envoy bootstrap config that connects to this server",
+		Action: bootstrapAction,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "node-id",
+				Usage:  "envoy node id (required)",
+				EnvVar: "ENVOY_XDS_NODE_ID",
+			},
+			cli.StringFlag{
+				Name:   "cluster",
+				Usage:  "envoy node cluster (required)",
+				EnvVar: "ENVOY_XDS_CLUSTER",
+			},
+			cli.StringFlag{
+				Name:   "region",
+				Usage:  "envoy node locality region",
+				EnvVar: "ENVOY_XDS_LOCALITY_REGION",
+			},
+			cli.StringFlag{
+				Name:   "zone",
+				Usage:  "envoy node locality zone",
+				EnvVar: "ENVOY_XDS_LOCALITY_ZONE",
+			},
+			cli.StringFlag{
+				Name:   "xds-host",
+				Usage:  "xds server host (ip address or dns name)",
+				Value:  "127.0.0.1",
+				EnvVar: "ENVOY_XDS_HOST",
+			},
+			cli.StringFlag{
+				Name:   "xds-port",
+				Usage:  "xds server port",
+				Value:  "8000",
+				EnvVar: "ENVOY_XDS_PORT",
+			},
+			cli.StringSliceFlag{
+				Name:   "backup-xds-addr",
+				Usage:  "backup xds server host:port, used in order while the primary is unhealthy",
+				EnvVar: "ENVOY_XDS_BACKUP_ADDRS",
+			},
+			cli.StringFlag{
+				Name:   "als-host",
+				Usage:  "als server host (ip address or dns name)",
+				Value:  "127.0.0.1",
+				EnvVar: "ENVOY_ALS_HOST",
+			},
+			cli.StringFlag{
+				Name:   "als-port",
+				Usage:  "als server port",
+				Value:  "8001",
+				EnvVar: "ENVOY_ALS_PORT",
+			},
+			cli.StringFlag{
+				Name:   "admin-host",
+				Usage:  "envoy admin listen host",
+				Value:  "127.0.0.1",
+				EnvVar: "ENVOY_ADMIN_LISTEN_HOST",
+			},
+			cli.StringFlag{
+				Name:   "admin-port",
+				Usage:  "envoy admin listen port(disabled if empty)",
+				Value:  "9800",
+				EnvVar: "ENVOY_ADMIN_LISTEN_PORT",
+			},
+			cli.BoolFlag{
+				Name:   "ads",
+				Usage:  "use aggregated discovery service (server needs --ads)",
+				EnvVar: "ENVOY_XDS_ADS",
+			},
+			cli.BoolFlag{
+				Name:   "delta",
+				Usage:  "use incremental(delta) xDS (server needs --delta)",
+				EnvVar: "ENVOY_XDS_DELTA",
+			},
+			cli.StringFlag{
+				Name:   "tls-ca",
+				Usage:  "/path/to/ca.pem verifies xds and als servers, enables upstream TLS",
+				EnvVar: "ENVOY_XDS_TLS_CA",
+			},
+			cli.StringFlag{
+				Name:   "tls-cert",
+				Usage:  "/path/to/cert.pem client certificate for mTLS",
+				EnvVar: "ENVOY_XDS_TLS_CERT",
+			},
+			cli.StringFlag{
+				Name:   "tls-key",
+				Usage:  "/path/to/key.pem client private key for mTLS",
+				EnvVar: "ENVOY_XDS_TLS_KEY",
+			},
+			cli.StringFlag{
+				Name:   "tls-sni",
+				Usage:  "SNI sent to xds and als servers",
+				EnvVar: "ENVOY_XDS_TLS_SNI",
+			},
+			cli.StringSliceFlag{
+				Name:  "rtds-layer",
+				Usage: "RTDS layer name (default: " + xds.BootstrapRuntimeLayerName + ")",
+			},
+			cli.BoolFlag{
+				Name:  "no-rtds",
+				Usage: "no RTDS layer",
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "output format, yaml or json",
+				Value: xds.BootstrapFormatYAML,
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "/path/to/envoy.yaml (stdout if empty)",
+			},
+		},
+	})
+}
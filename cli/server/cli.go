@@ -25,3 +25,11 @@ func initLogLevel(c *cli.Context) {
 		}
 	}
 }
+
+// initQuietLogLevel is initLogLevel for offline commands, resource building logs are shown only in debug mode
+func initQuietLogLevel(c *cli.Context) {
+	initLogLevel(c)
+	if c.GlobalBool("debug") != true {
+		colog.SetMinLevel(colog.LWarning)
+	}
+}
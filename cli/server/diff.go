@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func diffAction(c *cli.Context) error {
+	initQuietLogLevel(c)
+
+	var d *xds.SnapshotDiff
+	if baseDir := c.String("base-dir"); baseDir != "" {
+		wf := xds.NewWatchFile(
+			context.Background(),
+			xds.WatchAds(c.Bool("ads")),
+			xds.WatchDelta(c.Bool("delta")),
+		)
+		proposed := xds.ConfigFiles{
+			Cds:     c.String("cds-yaml"),
+			Eds:     c.String("eds-yaml"),
+			Rds:     c.String("rds-yaml"),
+			Lds:     c.String("lds-yaml"),
+			Sds:     c.String("sds-yaml"),
+			Runtime: c.String("runtime-yaml"),
+		}
+		diff, err := wf.DiffFileSets(xds.ConfigFilesFromDir(baseDir), proposed)
+		if err != nil {
+			return err
+		}
+		d = diff
+	} else {
+		if c.String("sds-yaml") != "" {
+			return fmt.Errorf("--sds-yaml requires --base-dir, secrets are not sent to the admin api")
+		}
+		proposed := xds.ConfigContents{}
+		contents := []struct {
+			flag string
+			dst  *string
+		}{
+			{"cds-yaml", &proposed.Cds},
+			{"eds-yaml", &proposed.Eds},
+			{"rds-yaml", &proposed.Rds},
+			{"lds-yaml", &proposed.Lds},
+			{"runtime-yaml", &proposed.Runtime},
+		}
+		for _, content := range contents {
+			if file := c.String(content.flag); file != "" {
+				data, err := ioutil.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				*content.dst = string(data)
+			}
+		}
+
+		d = new(xds.SnapshotDiff)
+		if err := adminPost(c, "/diff?node-group="+url.QueryEscape(c.String("node-group")), proposed, d); err != nil {
+			return err
+		}
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return err
+		}
+	} else {
+		printSnapshotDiff(d)
+	}
+
+	if c.Bool("exit-code") && 0 < len(d.Resources) {
+		return fmt.Errorf("%d resource(s) changed", len(d.Resources))
+	}
+	return nil
+}
+
+func printSnapshotDiff(d *xds.SnapshotDiff) {
+	if d.NodeGroup != "" {
+		fmt.Printf("node group: %s\n", d.NodeGroup)
+	}
+	fmt.Printf("version: %s -> %s\n", d.FromVersion, d.ToVersion)
+	if len(d.Resources) < 1 {
+		fmt.Println("no changes")
+		return
+	}
+
+	marks := map[string]string{
+		xds.DiffAdded:    "+",
+		xds.DiffRemoved:  "-",
+		xds.DiffModified: "~",
+	}
+	for _, r := range d.Resources {
+		fmt.Printf("%s %-5s %s\n", marks[r.Action], r.Type, r.Name)
+		for _, f := range r.Fields {
+			fmt.Printf("      %s: %s -> %s\n", f.Path, f.From, f.To)
+		}
+	}
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:  "diff",
+		Usage: "show the envoy resources changed by the proposed yaml files, against the running server or --base-dir",
+		Flags: append(adminClientFlags(),
+			cli.StringFlag{
+				Name:  "node-group",
+				Usage: "node group of the running server to compare",
+				Value: xds.DefaultNodeGroupName,
+			},
+			cli.StringFlag{
+				Name:  "base-dir",
+				Usage: "compare with <dir>/{cds,eds,rds,lds,sds,runtime}.yaml instead of the running server",
+			},
+			cli.StringFlag{
+				Name:  "cds-yaml",
+				Usage: "/path/to/proposed/cds.yaml (unchanged if empty)",
+			},
+			cli.StringFlag{
+				Name:  "eds-yaml",
+				Usage: "/path/to/proposed/eds.yaml (unchanged if empty)",
+			},
+			cli.StringFlag{
+				Name:  "rds-yaml",
+				Usage: "/path/to/proposed/rds.yaml (unchanged if empty)",
+			},
+			cli.StringFlag{
+				Name:  "lds-yaml",
+				Usage: "/path/to/proposed/lds.yaml (unchanged if empty)",
+			},
+			cli.StringFlag{
+				Name:  "sds-yaml",
+				Usage: "/path/to/proposed/sds.yaml (unchanged if empty, --base-dir only)",
+			},
+			cli.StringFlag{
+				Name:  "runtime-yaml",
+				Usage: "/path/to/proposed/runtime.yaml (unchanged if empty)",
+			},
+			cli.BoolFlag{
+				Name:  "ads",
+				Usage: "generate resources for ADS (--base-dir only, must match the server)",
+			},
+			cli.BoolFlag{
+				Name:  "delta",
+				Usage: "generate resources for DELTA_GRPC (--base-dir only, must match the server)",
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "output as json",
+			},
+			cli.BoolFlag{
+				Name:  "exit-code",
+				Usage: "exit non-zero if there are changes",
+			},
+		),
+		Action: diffAction,
+	})
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func historyListAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	list := make([]*xds.NodeGroupHistory, 0)
+	if err := adminGet(c, "/history", &list); err != nil {
+		return err
+	}
+
+	if name := c.Args().First(); name != "" {
+		for _, h := range list {
+			if h.NodeGroup == name {
+				list = []*xds.NodeGroupHistory{h}
+				break
+			}
+		}
+		if len(list) != 1 || list[0].NodeGroup != name {
+			return fmt.Errorf("node group '%s' not found", name)
+		}
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(list)
+	}
+	return printHistory(list, c.Bool("verbose"))
+}
+
+func historyRollbackAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	return historyApply(c, "/history/rollback")
+}
+
+func historyPinAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	return historyApply(c, "/history/pin")
+}
+
+func historyUnpinAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("node-group is required")
+	}
+
+	h := new(xds.NodeGroupHistory)
+	if err := adminPost(c, "/history/unpin?node-group="+url.QueryEscape(name), nil, h); err != nil {
+		return err
+	}
+	return printHistory([]*xds.NodeGroupHistory{h}, false)
+}
+
+func historyApply(c *cli.Context, path string) error {
+	if c.NArg() != 2 {
+		return fmt.Errorf("node-group and history id are required")
+	}
+	name := c.Args().Get(0)
+	id, err := strconv.ParseUint(c.Args().Get(1), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid history id '%s': %s", c.Args().Get(1), err.Error())
+	}
+
+	query := url.Values{}
+	query.Set("node-group", name)
+	query.Set("id", strconv.FormatUint(id, 10))
+
+	h := new(xds.NodeGroupHistory)
+	if err := adminPost(c, path+"?"+query.Encode(), nil, h); err != nil {
+		return err
+	}
+	return printHistory([]*xds.NodeGroupHistory{h}, false)
+}
+
+func printHistory(list []*xds.NodeGroupHistory, verbose bool) error {
+	for i, h := range list {
+		if 0 < i {
+			fmt.Println()
+		}
+		state := "tracking files"
+		if h.Pinned != 0 {
+			state = fmt.Sprintf("pinned to #%d", h.Pinned)
+		}
+		fmt.Printf("node group: %s (%s)\n", h.NodeGroup, state)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "\tID\tAPPLIED\tSOURCE\tVERSION\tCHANGES\n")
+		marked := false
+		for _, e := range h.Entries {
+			current := ""
+			if e.Version == h.Current && marked != true {
+				current = "*"
+				marked = true
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\n", current, e.Id, e.AppliedAt.Format(time.RFC3339), e.Source, e.Version, historyChanges(e.Changes))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if verbose {
+			for _, e := range h.Entries {
+				fmt.Printf("\n#%d %s\n", e.Id, e.Version)
+				for _, change := range e.Changes {
+					fmt.Printf("  %s\n", change)
+				}
+				files := make([]string, 0, len(e.Checksums))
+				for file := range e.Checksums {
+					files = append(files, file)
+				}
+				sort.Strings(files)
+				for _, file := range files {
+					fmt.Printf("  sha256 %s %s\n", e.Checksums[file], file)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func historyChanges(changes []string) string {
+	if len(changes) == 0 {
+		return "-"
+	}
+	if 3 < len(changes) {
+		return fmt.Sprintf("%s, ... (%d)", strings.Join(changes[:3], ", "), len(changes))
+	}
+	return strings.Join(changes, ", ")
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:  "history",
+		Usage: "list applied snapshots, rollback or pin a node group to an earlier snapshot",
+		Subcommands: []cli.Command{
+			{
+				Name:      "list",
+				Usage:     "list applied snapshots of each node group",
+				ArgsUsage: "[node-group]",
+				Flags: append(adminClientFlags(),
+					cli.BoolFlag{
+						Name:  "json",
+						Usage: "output as json",
+					},
+					cli.BoolFlag{
+						Name:  "verbose",
+						Usage: "show all changes and file checksums",
+					},
+				),
+				Action: historyListAction,
+			},
+			{
+				Name:      "rollback",
+				Usage:     "apply an earlier snapshot, the next file change is applied as usual",
+				ArgsUsage: "<node-group> <id>",
+				Flags:     adminClientFlags(),
+				Action:    historyRollbackAction,
+			},
+			{
+				Name:      "pin",
+				Usage:     "apply an earlier snapshot and ignore file changes until unpin",
+				ArgsUsage: "<node-group> <id>",
+				Flags:     adminClientFlags(),
+				Action:    historyPinAction,
+			},
+			{
+				Name:      "unpin",
+				Usage:     "reload the files and go back to file tracking",
+				ArgsUsage: "<node-group>",
+				Flags:     adminClientFlags(),
+				Action:    historyUnpinAction,
+			},
+		},
+	})
+}
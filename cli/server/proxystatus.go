@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+var (
+	proxyStatusTypes = []string{"CDS", "LDS", "EDS", "RDS", "SDS", "RTDS"}
+)
+
+func adminGet(c *cli.Context, path string, bind interface{}) error {
+	client := &http.Client{Timeout: c.Duration("timeout")}
+	res, err := client.Get(strings.TrimSuffix(c.String("admin-addr"), "/") + path)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin api %s: %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(bind)
+}
+
+// adminPost sends body as json if not nil
+func adminPost(c *cli.Context, path string, body interface{}, bind interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	client := &http.Client{Timeout: c.Duration("timeout")}
+	res, err := client.Post(strings.TrimSuffix(c.String("admin-addr"), "/")+path, "application/json", reqBody)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		e := struct {
+			Error string `json:"error"`
+		}{}
+		if err := json.NewDecoder(res.Body).Decode(&e); err == nil && e.Error != "" {
+			return fmt.Errorf("admin api %s: %s: %s", path, res.Status, e.Error)
+		}
+		return fmt.Errorf("admin api %s: %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(bind)
+}
+
+func proxyStatusAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	proxies := make([]*xds.ProxyStatus, 0)
+	if err := adminGet(c, "/proxy-status", &proxies); err != nil {
+		return err
+	}
+
+	if nodeId := c.Args().First(); nodeId != "" {
+		for _, p := range proxies {
+			if p.NodeId == nodeId {
+				return printProxyDetail(p)
+			}
+		}
+		return fmt.Errorf("node '%s' is not connected", nodeId)
+	}
+
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(proxies)
+	}
+	return printProxyStatus(proxies)
+}
+
+func printProxyStatus(proxies []*xds.ProxyStatus) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "NAME\tCLUSTER\tLOCALITY\t%s\tVERSION\n", strings.Join(proxyStatusTypes, "\t"))
+	for _, p := range proxies {
+		statuses := make([]string, len(proxyStatusTypes))
+		for i, name := range proxyStatusTypes {
+			statuses[i] = proxyTypeSyncStatus(p, name)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s/%s\t%s\t%s\n", p.NodeId, p.Cluster, p.Region, p.Zone, strings.Join(statuses, "\t"), p.BuildVersion)
+	}
+	return w.Flush()
+}
+
+func proxyTypeSyncStatus(p *xds.ProxyStatus, name string) string {
+	for _, t := range p.Types {
+		if t.Type == name {
+			return t.Status
+		}
+	}
+	return xds.ProxySyncStatusNotSent
+}
+
+func printProxyDetail(p *xds.ProxyStatus) error {
+	fmt.Printf("node:      %s\n", p.NodeId)
+	fmt.Printf("cluster:   %s\n", p.Cluster)
+	fmt.Printf("locality:  %s/%s\n", p.Region, p.Zone)
+	fmt.Printf("version:   %s\n", p.BuildVersion)
+	fmt.Printf("peer:      %s\n", strings.Join(p.PeerAddr, ","))
+	fmt.Printf("connected: %s\n", p.ConnectedAt.Format(time.RFC3339))
+	fmt.Println()
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "TYPE\tSTATUS\tSENT\tACKED\tNACKED\tUPDATED\n")
+	for _, t := range p.Types {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", t.Type, t.Status, t.SentVersion, t.AckedVersion, t.NackedVersion, t.UpdatedAt.Format(time.RFC3339))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, t := range p.Types {
+		if t.NackError != "" {
+			fmt.Printf("\n%s NACK(%s): %s\n", t.Type, t.NackedVersion, t.NackError)
+		}
+	}
+	return nil
+}
+
+func adminClientFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringFlag{
+			Name:   "admin-addr",
+			Usage:  "admin http api address",
+			Value:  "http://127.0.0.1:8002",
+			EnvVar: "XDS_ADMIN_ADDR",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "admin http api timeout",
+			Value: 10 * time.Second,
+		},
+	}
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:      "proxy-status",
+		Usage:     "show connected envoys and their xDS sync status",
+		ArgsUsage: "[node-id]",
+		Flags: append(adminClientFlags(),
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "output as json",
+			},
+		),
+		Action: proxyStatusAction,
+	})
+}
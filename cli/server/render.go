@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func renderAction(c *cli.Context) error {
+	initQuietLogLevel(c)
+
+	format := c.String("format")
+	if format != xds.BootstrapFormatYAML && format != xds.BootstrapFormatJSON {
+		return fmt.Errorf("--format must be %s or %s", xds.BootstrapFormatYAML, xds.BootstrapFormatJSON)
+	}
+
+	nodeGroups, err := parseNodeGroups(c.StringSlice("node-group"))
+	if err != nil {
+		return err
+	}
+
+	wf := xds.NewWatchFile(
+		context.Background(),
+		xds.WatchCdsConfigFile(c.String("cds-yaml")),
+		xds.WatchEdsConfigFile(c.String("eds-yaml")),
+		xds.WatchRdsConfigFile(c.String("rds-yaml")),
+		xds.WatchLdsConfigFile(c.String("lds-yaml")),
+		xds.WatchSdsConfigFile(c.String("sds-yaml")),
+		xds.WatchRuntimeConfigFile(c.String("runtime-yaml")),
+		xds.WatchNodeGroups(nodeGroups...),
+		xds.WatchAds(c.Bool("ads")),
+		xds.WatchDelta(c.Bool("delta")),
+	)
+
+	data, err := wf.Render(
+		c.String("group"),
+		xds.RenderFormat(format),
+		xds.RenderXds(c.Bool("xds")),
+		xds.RenderSecrets(c.Bool("with-secrets")),
+		xds.RenderAlsAddr(c.String("als-addr")),
+	)
+	if errs, ok := err.(xds.ValidationErrors); ok {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s: [%s] %s\n", e.Location(), e.NodeGroup, e.Message)
+		}
+		return fmt.Errorf("%d error(s) found", len(errs))
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.String("output") == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(c.String("output"), data, 0600)
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:  "render",
+		Usage: "render the generated envoy resources of a node group as static_resources (EDS and RDS inlined)",
+		Flags: append(configFileFlags(),
+			cli.StringFlag{
+				Name:  "group",
+				Usage: "name of the node group to render",
+				Value: xds.DefaultNodeGroupName,
+			},
+			cli.StringFlag{
+				Name:  "format",
+				Usage: "output format yaml or json",
+				Value: xds.BootstrapFormatYAML,
+			},
+			cli.BoolFlag{
+				Name:  "xds",
+				Usage: "render the resources as served over xDS (clusters, endpoints, routes, listeners, ...) instead of static_resources",
+			},
+			cli.BoolFlag{
+				Name:  "with-secrets",
+				Usage: "include the secrets of sds.yaml (private keys are printed)",
+			},
+			cli.StringFlag{
+				Name:  "als-addr",
+				Usage: "host:port of the als server that the access log of the static listener sends to",
+				Value: "127.0.0.1:8001",
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "/path/to/output file (stdout if empty)",
+				Value: "",
+			},
+		),
+		Action: renderAction,
+	})
+}
@@ -2,10 +2,14 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path"
+	"strings"
 	"syscall"
+	"time"
 
 	"gopkg.in/urfave/cli.v1"
 
@@ -18,25 +22,46 @@ func serverAction(c *cli.Context) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	nodeId := c.String("node-id")
 	xdsListenAddr := c.String("xds-listen-addr")
 	alsListenAddr := c.String("als-listen-addr")
 
-	if nodeId == "" {
-		hostname, err := os.Hostname()
-		if err != nil {
-			return err
-		}
-		nodeId = hostname
+	if nodeId := c.String("node-id"); nodeId != "" {
+		log.Printf("warn: --node-id(XDS_NODE_ID) %s is deprecated and ignored, every node is served by --node-group or the default group", nodeId)
+	}
+
+	if (c.String("tls-cert") == "") != (c.String("tls-key") == "") {
+		return fmt.Errorf("both --tls-cert and --tls-key are required")
+	}
+	if c.String("tls-client-ca") != "" && c.String("tls-cert") == "" {
+		return fmt.Errorf("--tls-client-ca requires --tls-cert and --tls-key")
+	}
+
+	nackThreshold := c.Float64("nack-rollback-threshold")
+	if nackThreshold < 0 || 1 < nackThreshold {
+		return fmt.Errorf("--nack-rollback-threshold must be between 0 and 1")
+	}
+
+	nodeGroups, err := parseNodeGroups(c.StringSlice("node-group"))
+	if err != nil {
+		return err
 	}
 
 	wf := xds.NewWatchFile(
 		ctx,
-		nodeId,
 		xds.WatchCdsConfigFile(c.String("cds-yaml")),
 		xds.WatchEdsConfigFile(c.String("eds-yaml")),
 		xds.WatchRdsConfigFile(c.String("rds-yaml")),
 		xds.WatchLdsConfigFile(c.String("lds-yaml")),
+		xds.WatchSdsConfigFile(c.String("sds-yaml")),
+		xds.WatchRuntimeConfigFile(c.String("runtime-yaml")),
+		xds.WatchNodeGroups(nodeGroups...),
+		xds.WatchAds(c.Bool("ads")),
+		xds.WatchDelta(c.Bool("delta")),
+		xds.WatchStateDir(c.String("state-dir")),
+		xds.WatchHistorySize(c.Int("history-size")),
+		xds.WatchPolling(c.Bool("watch-polling")),
+		xds.WatchPollInterval(c.Duration("watch-poll-interval")),
+		xds.WatchDebounce(c.Duration("watch-debounce")),
 	)
 
 	svr := xds.NewServer(
@@ -44,36 +69,56 @@ func serverAction(c *cli.Context) error {
 		wf.Cache(),
 		xds.XdsListenAddr(xdsListenAddr),
 		xds.AlsListenAddr(alsListenAddr),
+		xds.AdminListenAddr(c.String("admin-listen-addr")),
+		xds.AdminWriteEnable(c.Bool("admin-write")),
+		xds.TLSCertFile(c.String("tls-cert"), c.String("tls-key")),
+		xds.TLSClientCAFile(c.String("tls-client-ca")),
+		xds.ServerWatchFile(wf),
+		xds.RollbackOnNack(nackThreshold),
+		xds.ShutdownTimeout(c.Duration("shutdown-timeout")),
 	)
 
 	log.Printf("info: server starting...")
 
-	// initial load all files
-	if err := wf.ReloadAll(); err != nil {
+	// initial load all files, fallback to persisted snapshot
+	if err := wf.InitialLoad(); err != nil {
 		log.Printf("error: load file(s) error: %s", err.Error())
 		return err
 	}
 	if err := wf.Watch(ctx); err != nil {
-		log.Printf("error: failed create to fsnotify: %s", err.Error())
+		log.Printf("error: failed to watch file(s): %s", err.Error())
 		return err
 	}
 
 	go watchSignal(wf, cancel)
 
-	go svr.Start()
+	startErr := make(chan error, 1)
+	go func() {
+		startErr <- svr.Start()
+	}()
 
-	<-ctx.Done() // wait stop
+	var serveErr error
+	select {
+	case <-ctx.Done(): // wait stop
+	case serveErr = <-startErr:
+		if serveErr != nil {
+			log.Printf("error: server start error: %+v", serveErr)
+		}
+		cancel()
+	}
 
 	log.Printf("info: server stopping...")
 
-	svr.Stop()
+	if err := svr.Stop(); err != nil && serveErr == nil {
+		serveErr = err
+	}
 
 	log.Printf("info: server stop")
-	return nil
+	return serveErr
 }
 
 func watchSignal(wf *xds.WatchFile, cancel context.CancelFunc) {
-	trap := make(chan os.Signal)
+	trap := make(chan os.Signal, 1)
 	signal.Notify(trap, syscall.SIGTERM)
 	signal.Notify(trap, syscall.SIGHUP)
 	signal.Notify(trap, syscall.SIGQUIT)
@@ -98,13 +143,143 @@ func watchSignal(wf *xds.WatchFile, cancel context.CancelFunc) {
 	}
 }
 
+func parseNodeGroups(values []string) ([]xds.NodeGroup, error) {
+	groups := make([]xds.NodeGroup, len(values))
+	names := make(map[string]struct{}, len(values))
+	for i, value := range values {
+		group, err := parseNodeGroup(value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[group.Name]; ok {
+			return nil, fmt.Errorf("duplicate node-group name '%s'", group.Name)
+		}
+		names[group.Name] = struct{}{}
+		log.Printf("info: node group %s cluster=%s node-id=%s dir=%s", group.Name, group.Cluster, group.NodeId, group.Dir)
+		groups[i] = group
+	}
+	return groups, nil
+}
+
+// format: <name>:<dir>[:cluster=<glob>][:node-id=<glob>]
+// name is taken from the front and the cluster=/node-id= matches from the end, so that <dir> may contain ':'
+func parseNodeGroup(value string) (xds.NodeGroup, error) {
+	values := strings.SplitN(value, ":", 2)
+	if len(values) < 2 {
+		return xds.NodeGroup{}, fmt.Errorf("invalid node-group '%s': <name>:<dir>:cluster=<glob>|node-id=<glob> required", value)
+	}
+
+	group := xds.NodeGroup{
+		Name: values[0],
+	}
+	if group.Name == "" || group.Name == xds.DefaultNodeGroupName {
+		return xds.NodeGroup{}, fmt.Errorf("invalid node-group '%s': name must not be empty or '%s'", value, xds.DefaultNodeGroupName)
+	}
+
+	rest := values[1]
+	for {
+		i := strings.LastIndex(rest, ":")
+		if i < 0 {
+			break
+		}
+		kv := strings.SplitN(rest[i+1:], "=", 2)
+		if len(kv) != 2 {
+			break
+		}
+		var pattern *string
+		switch kv[0] {
+		case "cluster":
+			pattern = &group.Cluster
+		case "node-id":
+			pattern = &group.NodeId
+		}
+		if pattern == nil {
+			break // part of dir
+		}
+		if *pattern != "" {
+			return xds.NodeGroup{}, fmt.Errorf("invalid node-group '%s': duplicate match '%s'", value, kv[0])
+		}
+		if _, err := path.Match(kv[1], ""); err != nil {
+			return xds.NodeGroup{}, fmt.Errorf("invalid node-group '%s': %s '%s': %w", value, kv[0], kv[1], err)
+		}
+		*pattern = kv[1]
+		rest = rest[:i]
+	}
+	group.Dir = rest
+
+	if group.Dir == "" {
+		return xds.NodeGroup{}, fmt.Errorf("invalid node-group '%s': dir must not be empty", value)
+	}
+	if group.Cluster == "" && group.NodeId == "" {
+		return xds.NodeGroup{}, fmt.Errorf("invalid node-group '%s': <name>:<dir>:cluster=<glob>|node-id=<glob> required", value)
+	}
+	return group, nil
+}
+
+// configFileFlags are the flags to load config files, shared by server and validate
+func configFileFlags() []cli.Flag {
+	return []cli.Flag{
+		cli.StringSliceFlag{
+			Name:   "node-group",
+			Usage:  "node group served from <dir>/{cds,eds,rds,lds}.yaml(or {cds,eds,rds,lds}.d directories), format: <name>:<dir>:cluster=<glob>[:node-id=<glob>] (unmatched nodes use the default group from --cds-yaml etc.)",
+			EnvVar: "XDS_NODE_GROUPS",
+		},
+		cli.BoolFlag{
+			Name:   "ads",
+			Usage:  "serve resources over ADS(envoy bootstrap must use ads_config)",
+			EnvVar: "XDS_ADS",
+		},
+		cli.BoolFlag{
+			Name:   "delta",
+			Usage:  "advertise DELTA_GRPC(incremental xDS) to envoy",
+			EnvVar: "XDS_DELTA",
+		},
+		cli.StringFlag{
+			Name:   "cds-yaml",
+			Usage:  "/path/to/cds.yaml or conf.d directory(/path/to/cds.d) whose *.yaml files are merged",
+			Value:  "./cds.yaml",
+			EnvVar: "CDS_YAML",
+		},
+		cli.StringFlag{
+			Name:   "eds-yaml",
+			Usage:  "/path/to/eds.yaml or conf.d directory",
+			Value:  "./eds.yaml",
+			EnvVar: "EDS_YAML",
+		},
+		cli.StringFlag{
+			Name:   "rds-yaml",
+			Usage:  "/path/to/rds.yaml or conf.d directory",
+			Value:  "./rds.yaml",
+			EnvVar: "RDS_YAML",
+		},
+		cli.StringFlag{
+			Name:   "lds-yaml",
+			Usage:  "/path/to/lds.yaml or conf.d directory with a single file",
+			Value:  "./lds.yaml",
+			EnvVar: "LDS_YAML",
+		},
+		cli.StringFlag{
+			Name:   "sds-yaml",
+			Usage:  "/path/to/sds.yaml or conf.d directory (optional, secrets are not served if empty)",
+			Value:  "",
+			EnvVar: "SDS_YAML",
+		},
+		cli.StringFlag{
+			Name:   "runtime-yaml",
+			Usage:  "/path/to/runtime.yaml or conf.d directory (optional, runtime layer is not served if empty)",
+			Value:  "",
+			EnvVar: "RUNTIME_YAML",
+		},
+	}
+}
+
 func init() {
 	addCommand(cli.Command{
 		Name: "server",
-		Flags: []cli.Flag{
+		Flags: append(configFileFlags(),
 			cli.StringFlag{
 				Name:   "node-id",
-				Usage:  "envoy node-id(must be the value specified in node.id in enovy.yaml)",
+				Usage:  "deprecated and ignored, use --node-group(node-id=<glob>) instead",
 				Value:  "",
 				EnvVar: "XDS_NODE_ID",
 			},
@@ -121,30 +296,76 @@ func init() {
 				EnvVar: "ALS_LISTEN_ADDR",
 			},
 			cli.StringFlag{
-				Name:   "cds-yaml",
-				Usage:  "/path/to/cds.yaml",
-				Value:  "./cds.yaml",
-				EnvVar: "CDS_YAML",
+				Name:   "admin-listen-addr",
+				Usage:  "admin http api listen address(disabled if empty)",
+				Value:  "[127.0.0.1]:8002",
+				EnvVar: "ADMIN_LISTEN_ADDR",
+			},
+			cli.BoolFlag{
+				Name:   "admin-write",
+				Usage:  "enable admin http api that changes the served snapshots or builds posted yaml(/history/rollback, /history/pin, /history/unpin, /diff), it has no authentication",
+				EnvVar: "ADMIN_WRITE",
 			},
 			cli.StringFlag{
-				Name:   "eds-yaml",
-				Usage:  "/path/to/eds.yaml",
-				Value:  "./eds.yaml",
-				EnvVar: "EDS_YAML",
+				Name:   "tls-cert",
+				Usage:  "/path/to/cert.pem enables TLS on xds and als listeners(reloaded on change)",
+				Value:  "",
+				EnvVar: "XDS_TLS_CERT",
 			},
 			cli.StringFlag{
-				Name:   "rds-yaml",
-				Usage:  "/path/to/rds.yaml",
-				Value:  "./rds.yaml",
-				EnvVar: "RDS_YAML",
+				Name:   "tls-key",
+				Usage:  "/path/to/key.pem",
+				Value:  "",
+				EnvVar: "XDS_TLS_KEY",
 			},
 			cli.StringFlag{
-				Name:   "lds-yaml",
-				Usage:  "/path/to/lds.yaml",
-				Value:  "./lds.yaml",
-				EnvVar: "LDS_YAML",
+				Name:   "tls-client-ca",
+				Usage:  "/path/to/ca.pem requires client certificate(mTLS)",
+				Value:  "",
+				EnvVar: "XDS_TLS_CLIENT_CA",
 			},
-		},
+			cli.StringFlag{
+				Name:   "state-dir",
+				Usage:  "/path/to/dir persists applied snapshots, served at startup when yaml is broken(disabled if empty)",
+				Value:  "",
+				EnvVar: "XDS_STATE_DIR",
+			},
+			cli.IntFlag{
+				Name:   "history-size",
+				Usage:  "number of applied snapshots kept for each node group (history command)",
+				Value:  10,
+				EnvVar: "XDS_HISTORY_SIZE",
+			},
+			cli.BoolFlag{
+				Name:   "watch-polling",
+				Usage:  "find file changes by polling instead of fsnotify(file systems without inotify)",
+				EnvVar: "XDS_WATCH_POLLING",
+			},
+			cli.DurationFlag{
+				Name:   "watch-poll-interval",
+				Usage:  "interval to poll the files, also used while fsnotify watching is lost",
+				Value:  2 * time.Second,
+				EnvVar: "XDS_WATCH_POLL_INTERVAL",
+			},
+			cli.DurationFlag{
+				Name:   "watch-debounce",
+				Usage:  "wait a burst of file events to settle before reloading",
+				Value:  100 * time.Millisecond,
+				EnvVar: "XDS_WATCH_DEBOUNCE",
+			},
+			cli.DurationFlag{
+				Name:   "shutdown-timeout",
+				Usage:  "deadline to wait in-flight xds/als streams on shutdown",
+				Value:  10 * time.Second,
+				EnvVar: "XDS_SHUTDOWN_TIMEOUT",
+			},
+			cli.Float64Flag{
+				Name:   "nack-rollback-threshold",
+				Usage:  "share of nodes in a node group rejecting(NACK) a version to rollback to the last-known-good snapshot(0 disables rollback)",
+				Value:  0.5,
+				EnvVar: "XDS_NACK_ROLLBACK_THRESHOLD",
+			},
+		),
 		Action: serverAction,
 	})
 }
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"path"
+	"testing"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func TestParseNodeGroup(t *testing.T) {
+	tests := []struct {
+		value  string
+		expect xds.NodeGroup
+	}{
+		{"edge:/app/vol/edge:cluster=edge-*", xds.NodeGroup{Name: "edge", Dir: "/app/vol/edge", Cluster: "edge-*"}},
+		{"canary:./canary:cluster=canary:node-id=envoy-*", xds.NodeGroup{Name: "canary", Dir: "./canary", Cluster: "canary", NodeId: "envoy-*"}},
+		{"win:C:\\xds\\win:node-id=envoy-*", xds.NodeGroup{Name: "win", Dir: "C:\\xds\\win", NodeId: "envoy-*"}},
+		{"vol:/mnt/a:b/c=d:cluster=vol", xds.NodeGroup{Name: "vol", Dir: "/mnt/a:b/c=d", Cluster: "vol"}},
+	}
+	for _, tt := range tests {
+		group, err := parseNodeGroup(tt.value)
+		if err != nil {
+			t.Errorf("%s: %s", tt.value, err.Error())
+			continue
+		}
+		if group != tt.expect {
+			t.Errorf("%s: expect %+v actual %+v", tt.value, tt.expect, group)
+		}
+	}
+
+	for _, value := range []string{
+		"edge",
+		"edge:/app/vol/edge",
+		":/app/vol/edge:cluster=edge",
+		"default:/app/vol/edge:cluster=edge",
+		"edge::cluster=edge",
+		"edge:/app/vol/edge:cluster=a:cluster=b",
+	} {
+		if _, err := parseNodeGroup(value); err == nil {
+			t.Errorf("%s must be rejected", value)
+		}
+	}
+
+	if _, err := parseNodeGroup("canary:/app/vol/canary:cluster=canary-["); errors.Is(err, path.ErrBadPattern) != true {
+		t.Errorf("malformed glob must be rejected with ErrBadPattern: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func validateAction(c *cli.Context) error {
+	initQuietLogLevel(c)
+
+	nodeGroups, err := parseNodeGroups(c.StringSlice("node-group"))
+	if err != nil {
+		return err
+	}
+
+	wf := xds.NewWatchFile(
+		context.Background(),
+		xds.WatchCdsConfigFile(c.String("cds-yaml")),
+		xds.WatchEdsConfigFile(c.String("eds-yaml")),
+		xds.WatchRdsConfigFile(c.String("rds-yaml")),
+		xds.WatchLdsConfigFile(c.String("lds-yaml")),
+		xds.WatchSdsConfigFile(c.String("sds-yaml")),
+		xds.WatchRuntimeConfigFile(c.String("runtime-yaml")),
+		xds.WatchNodeGroups(nodeGroups...),
+		xds.WatchAds(c.Bool("ads")),
+		xds.WatchDelta(c.Bool("delta")),
+	)
+
+	errs := wf.Validate()
+	if c.Bool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(errs); err != nil {
+			return err
+		}
+	} else {
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s: [%s] %s\n", e.Location(), e.NodeGroup, e.Message)
+		}
+	}
+
+	if 0 < len(errs) {
+		return fmt.Errorf("%d error(s) found", len(errs))
+	}
+	if c.Bool("json") != true {
+		fmt.Printf("ok: %d node group(s) are valid\n", len(nodeGroups)+1)
+	}
+	return nil
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:  "validate",
+		Usage: "check the config files and the snapshot consistency without serving them, exit non-zero on error",
+		Flags: append(configFileFlags(),
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "output errors as json",
+			},
+		),
+		Action: validateAction,
+	})
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"gopkg.in/urfave/cli.v1"
+
+	"github.com/octu0/example-envoy-xds"
+)
+
+func xdsClientAction(c *cli.Context) error {
+	initLogLevel(c)
+
+	if (c.String("tls-cert") == "") != (c.String("tls-key") == "") {
+		return fmt.Errorf("both --tls-cert and --tls-key are required")
+	}
+
+	metadata := make(map[string]string, len(c.StringSlice("metadata")))
+	for _, value := range c.StringSlice("metadata") {
+		kv := strings.SplitN(value, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid --metadata '%s': <key>=<value> required", value)
+		}
+		metadata[kv[0]] = kv[1]
+	}
+
+	types := c.StringSlice("type")
+	if len(types) < 1 {
+		types = xds.XdsClientTypes
+	}
+	subscriptions := make(map[string][]string, len(types))
+	for _, value := range types {
+		// format: <type>[=<name>,<name>...]
+		kv := strings.SplitN(value, "=", 2)
+		if _, ok := xds.XdsTypeURL(kv[0]); ok != true {
+			return fmt.Errorf("invalid --type '%s': cds, eds, rds, lds, sds or rtds required", value)
+		}
+		names := []string{}
+		if len(kv) == 2 && kv[1] != "" {
+			names = strings.Split(kv[1], ",")
+		}
+		subscriptions[kv[0]] = append(subscriptions[kv[0]], names...)
+	}
+
+	nackTypes := make([]string, 0, len(c.StringSlice("nack")))
+	for _, value := range c.StringSlice("nack") {
+		if strings.EqualFold(value, "all") {
+			nackTypes = append(nackTypes, "CDS", "EDS", "RDS", "LDS", "SDS", "RTDS")
+			continue
+		}
+		if _, ok := xds.XdsTypeURL(value); ok != true {
+			return fmt.Errorf("invalid --nack '%s': cds, eds, rds, lds, sds, rtds or all required", value)
+		}
+		nackTypes = append(nackTypes, value)
+	}
+
+	client, err := xds.NewXdsClient(
+		xds.XdsClientAddr(c.String("xds-addr")),
+		xds.XdsClientNode(c.String("node-id"), c.String("cluster")),
+		xds.XdsClientLocality(c.String("region"), c.String("zone")),
+		xds.XdsClientMetadata(metadata),
+		xds.XdsClientADS(c.Bool("ads")),
+		xds.XdsClientDelta(c.Bool("delta")),
+		xds.XdsClientTLS(c.String("tls-ca"), c.String("tls-cert"), c.String("tls-key"), c.String("tls-sni")),
+		xds.XdsClientSubscriptions(subscriptions),
+		xds.XdsClientNack(nackTypes...),
+	)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if 0 < c.Duration("timeout") {
+		ctx, cancel = context.WithTimeout(ctx, c.Duration("timeout"))
+		defer cancel()
+	}
+	go func() {
+		trap := make(chan os.Signal, 1)
+		signal.Notify(trap, syscall.SIGTERM, syscall.SIGINT)
+		select {
+		case <-trap:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	log.Printf("info: subscribe %s to %s as node-id=%s cluster=%s", strings.Join(types, " "), c.String("xds-addr"), c.String("node-id"), c.String("cluster"))
+
+	count := c.Int("count")
+	received := 0
+	enc := json.NewEncoder(os.Stdout)
+	err = client.Run(ctx, func(res *xds.XdsResponse) bool {
+		if c.Bool("json") {
+			if err := enc.Encode(res); err != nil {
+				log.Printf("warn: %s", err.Error())
+			}
+		} else {
+			printXdsResponse(res)
+		}
+		received += 1
+		return count < 1 || received < count
+	})
+	if err != nil {
+		return err
+	}
+	if ctx.Err() == context.DeadlineExceeded && 0 < count {
+		return fmt.Errorf("timeout: %d/%d response(s) received", received, count)
+	}
+	return nil
+}
+
+func printXdsResponse(res *xds.XdsResponse) {
+	result := "ack"
+	if res.Nack {
+		result = "nack"
+	}
+	fmt.Printf("%s version=%s nonce=%s resources=%d %s\n", res.Type, res.Version, res.Nonce, len(res.Resources), result)
+	for _, r := range res.Resources {
+		if r.Version != "" {
+			fmt.Printf("  + %s (%s)\n", r.Name, r.Version)
+		} else {
+			fmt.Printf("  + %s\n", r.Name)
+		}
+	}
+	for _, name := range res.Removed {
+		fmt.Printf("  - %s\n", name)
+	}
+}
+
+func init() {
+	addCommand(cli.Command{
+		Name:  "xds-client",
+		Usage: "subscribe resources from a xds server like an envoy node and print each response",
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "xds-addr",
+				Usage:  "xds server address host:port",
+				Value:  "127.0.0.1:8000",
+				EnvVar: "XDS_ADDR",
+			},
+			cli.StringFlag{
+				Name:   "node-id",
+				Usage:  "node.id",
+				Value:  "xds-client",
+				EnvVar: "ENVOY_NODE_ID",
+			},
+			cli.StringFlag{
+				Name:   "cluster",
+				Usage:  "node.cluster",
+				Value:  "xds-client",
+				EnvVar: "ENVOY_CLUSTER",
+			},
+			cli.StringFlag{
+				Name:   "region",
+				Usage:  "node.locality.region",
+				Value:  "",
+				EnvVar: "ENVOY_REGION",
+			},
+			cli.StringFlag{
+				Name:   "zone",
+				Usage:  "node.locality.zone",
+				Value:  "",
+				EnvVar: "ENVOY_ZONE",
+			},
+			cli.StringSliceFlag{
+				Name:  "metadata",
+				Usage: "node.metadata <key>=<value>",
+			},
+			cli.StringSliceFlag{
+				Name:  "type",
+				Usage: "subscribe type <type>[=<name>,<name>...] (cds, eds, rds, lds, sds, rtds; default cds, eds, rds, lds with all resources)",
+			},
+			cli.BoolFlag{
+				Name:  "ads",
+				Usage: "subscribe over a single ADS stream",
+			},
+			cli.BoolFlag{
+				Name:  "delta",
+				Usage: "subscribe with incremental xDS",
+			},
+			cli.StringSliceFlag{
+				Name:  "nack",
+				Usage: "reject(NACK) the responses of type (cds, eds, rds, lds, sds, rtds or all) instead of ACK",
+			},
+			cli.StringFlag{
+				Name:  "tls-ca",
+				Usage: "/path/to/ca.pem verifies the xds server (TLS)",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "tls-cert",
+				Usage: "/path/to/cert.pem client certificate (mTLS)",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "tls-key",
+				Usage: "/path/to/key.pem",
+				Value: "",
+			},
+			cli.StringFlag{
+				Name:  "tls-sni",
+				Usage: "server name to verify",
+				Value: "",
+			},
+			cli.IntFlag{
+				Name:  "count",
+				Usage: "exit after receiving count responses (0 runs until interrupted)",
+				Value: 0,
+			},
+			cli.DurationFlag{
+				Name:  "timeout",
+				Usage: "exit after timeout, non-zero if --count responses are not received (0 disables)",
+				Value: 0,
+			},
+			cli.BoolFlag{
+				Name:  "json",
+				Usage: "print each response as a json line with the resources",
+			},
+		},
+		Action: xdsClientAction,
+	})
+}
@@ -0,0 +1,97 @@
+package xds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// NodeGroupConfDirSuffix replaces .yaml of the node group file names,
+	// <dir>/eds.d is used instead of <dir>/eds.yaml if it exists
+	NodeGroupConfDirSuffix string = ".d"
+)
+
+// isConfDir reports whether path is a conf.d directory
+func isConfDir(path string) bool {
+	if path == "" {
+		return false
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return stat.IsDir()
+}
+
+// isConfFileName reports whether name is a yaml file merged from a conf.d directory,
+// hidden files(editor swap files etc.) are ignored
+func isConfFileName(name string) bool {
+	base := filepath.Base(name)
+	if strings.HasPrefix(base, ".") {
+		return false
+	}
+	ext := filepath.Ext(base)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// configFiles returns the files of path, path is a yaml file or
+// a conf.d directory whose *.yaml and *.yml files are merged in lexical order
+func configFiles(path string) ([]string, error) {
+	if isConfDir(path) != true {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || isConfFileName(e.Name()) != true {
+			continue
+		}
+		files = append(files, filepath.Join(path, e.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// matchConfigPath reports whether name is path or a yaml file in the conf.d directory path
+func matchConfigPath(name, path string) bool {
+	if path == "" {
+		return false
+	}
+	if equalPath(name, path) {
+		return true
+	}
+	if isConfFileName(name) != true {
+		return false
+	}
+	return equalPath(filepath.Dir(name), path) && isConfDir(path)
+}
+
+// fileError names file in err when it is merged from the conf.d directory path
+func fileError(path, file string, err error) error {
+	if path == file {
+		return err
+	}
+	return fmt.Errorf("%s: %s", file, err.Error())
+}
+
+// duplicateError reports name defined in two files of a conf.d directory
+func duplicateError(kind, name string, first, second string) error {
+	return fmt.Errorf("duplicate %s name '%s' in %s and %s", kind, name, first, second)
+}
+
+// nodeGroupConfigPath returns <dir>/<name without .yaml>.d if it is a directory, otherwise <dir>/<name>
+func nodeGroupConfigPath(dir string, name string) string {
+	confDir := filepath.Join(dir, strings.TrimSuffix(name, filepath.Ext(name))+NodeGroupConfDirSuffix)
+	if isConfDir(confDir) {
+		return confDir
+	}
+	return filepath.Join(dir, name)
+}
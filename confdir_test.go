@@ -0,0 +1,202 @@
+package xds
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testWriteFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("mkdir %s: %s", name, err.Error())
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("write %s: %s", name, err.Error())
+		}
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	testWriteFiles(t, dir, map[string]string{
+		"b.yaml":          "",
+		"a.yml":           "",
+		".a.yaml.swp":     "",
+		"c.yaml~":         "",
+		"README":          "",
+		"sub/d.yaml":      "",
+		"single/one.yaml": "",
+	})
+
+	files, err := configFiles(dir)
+	if err != nil {
+		t.Fatalf("configFiles: %s", err.Error())
+	}
+	expect := []string{filepath.Join(dir, "a.yml"), filepath.Join(dir, "b.yaml")}
+	if strings.Join(files, ",") != strings.Join(expect, ",") {
+		t.Errorf("expect %v actual %v", expect, files)
+	}
+
+	file := filepath.Join(dir, "b.yaml")
+	if files, _ := configFiles(file); len(files) != 1 || files[0] != file {
+		t.Errorf("a file must be returned as is: %v", files)
+	}
+
+	if matchConfigPath(filepath.Join(dir, "new.yaml"), dir) != true {
+		t.Errorf("a yaml file added to conf.d must match")
+	}
+	if matchConfigPath(filepath.Join(dir, ".new.yaml.swp"), dir) {
+		t.Errorf("hidden files must not match")
+	}
+	if matchConfigPath(filepath.Join(dir, "b.yaml"), filepath.Join(dir, "a.yml")) {
+		t.Errorf("a sibling of a file must not match")
+	}
+}
+
+func TestConfDirLoad(t *testing.T) {
+	cds := func(name string) string {
+		return "- name: " + name + "\n  lb-policy: round-robin\n  health-check: {path: /ready, status: [200], timeout: 3, interval: 3, healthy: 3, unhealthy: 3}\n"
+	}
+
+	t.Run("merge", func(tt *testing.T) {
+		dir := tt.TempDir()
+		testWriteFiles(tt, dir, map[string]string{
+			"team-a.yaml": cds("a1") + cds("a2"),
+			"team-b.yaml": cds("b1"),
+		})
+		configs, err := NewWatchFile(context.Background()).loadCds(dir)
+		if err != nil {
+			tt.Fatalf("load: %s", err.Error())
+		}
+		if names := strings.Join(cdsClusterNames(configs), ","); names != "a1,a2,b1" {
+			tt.Errorf("merged names: %s", names)
+		}
+	})
+	t.Run("duplicate", func(tt *testing.T) {
+		dir := tt.TempDir()
+		testWriteFiles(tt, dir, map[string]string{
+			"team-a.yaml": cds("shared"),
+			"team-b.yaml": cds("shared"),
+		})
+		_, err := NewWatchFile(context.Background()).loadCds(dir)
+		if err == nil {
+			tt.Fatalf("duplicate cluster across files must be rejected")
+		}
+		for _, name := range []string{"team-a.yaml", "team-b.yaml", "'shared'"} {
+			if strings.Contains(err.Error(), name) != true {
+				tt.Errorf("error must contain %s: %s", name, err.Error())
+			}
+		}
+	})
+	t.Run("broken", func(tt *testing.T) {
+		dir := tt.TempDir()
+		testWriteFiles(tt, dir, map[string]string{
+			"team-a.yaml": cds("a1"),
+			"team-b.yaml": "- name: [broken\n",
+		})
+		_, err := NewWatchFile(context.Background()).loadCds(dir)
+		if err == nil || strings.HasPrefix(err.Error(), filepath.Join(dir, "team-b.yaml")+": ") != true {
+			tt.Errorf("error must name the offending file: %v", err)
+		}
+	})
+	t.Run("lds", func(tt *testing.T) {
+		data, err := ioutil.ReadFile(NodeGroupLdsFileName)
+		if err != nil {
+			tt.Fatalf("read lds.yaml: %s", err.Error())
+		}
+		dir := tt.TempDir()
+		testWriteFiles(tt, dir, map[string]string{"listener.yaml": string(data)})
+		if _, err := NewWatchFile(context.Background()).loadLds(dir); err != nil {
+			tt.Errorf("single file must be loaded: %s", err.Error())
+		}
+		testWriteFiles(tt, dir, map[string]string{"another.yaml": string(data)})
+		if _, err := NewWatchFile(context.Background()).loadLds(dir); err == nil {
+			tt.Errorf("two listener files must be rejected")
+		}
+	})
+	t.Run("runtime", func(tt *testing.T) {
+		dir := tt.TempDir()
+		testWriteFiles(tt, dir, map[string]string{
+			"a.yaml": "feature.a: true\n",
+			"b.yaml": "feature.b: 10\n",
+		})
+		config, err := NewWatchFile(context.Background()).loadRuntime(dir)
+		if err != nil {
+			tt.Fatalf("load: %s", err.Error())
+		}
+		if len(config) != 2 {
+			tt.Errorf("merged keys: %v", config)
+		}
+		testWriteFiles(tt, dir, map[string]string{"c.yaml": "feature.a: false\n"})
+		if _, err := NewWatchFile(context.Background()).loadRuntime(dir); err == nil {
+			tt.Errorf("duplicate runtime key must be rejected")
+		}
+	})
+}
+
+func TestNodeGroupConfDir(t *testing.T) {
+	dir := t.TempDir()
+	testWriteFiles(t, dir, map[string]string{
+		"cds.yaml":     "",
+		"eds.d/a.yaml": "",
+	})
+
+	files := ConfigFilesFromDir(dir)
+	if files.Cds != filepath.Join(dir, NodeGroupCdsFileName) {
+		t.Errorf("cds: %s", files.Cds)
+	}
+	if files.Eds != filepath.Join(dir, "eds.d") {
+		t.Errorf("eds.d must be used: %s", files.Eds)
+	}
+}
+
+func TestValidateDuplicateVHost(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{NodeGroupCdsFileName, NodeGroupEdsFileName, NodeGroupLdsFileName} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %s", name, err.Error())
+		}
+		testWriteFiles(t, dir, map[string]string{name: string(data)})
+	}
+	rds, err := ioutil.ReadFile(NodeGroupRdsFileName)
+	if err != nil {
+		t.Fatalf("read %s: %s", NodeGroupRdsFileName, err.Error())
+	}
+	// the same vhosts in one file and in a conf.d directory
+	testWriteFiles(t, dir, map[string]string{
+		NodeGroupRdsFileName: string(rds) + string(rds),
+		"rds.d/a.yaml":       string(rds),
+		"rds.d/b.yaml":       string(rds),
+	})
+
+	tests := []struct {
+		name   string
+		rds    string
+		expect string
+	}{
+		{"file", filepath.Join(dir, NodeGroupRdsFileName), "duplicate vhost name 'vhost-api'"},
+		{"conf.d", filepath.Join(dir, "rds.d"), "duplicate vhost name 'vhost-api' in " + filepath.Join(dir, "rds.d", "a.yaml") + " and " + filepath.Join(dir, "rds.d", "b.yaml")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(tc *testing.T) {
+			w := NewWatchFile(context.Background(),
+				WatchCdsConfigFile(filepath.Join(dir, NodeGroupCdsFileName)),
+				WatchEdsConfigFile(filepath.Join(dir, NodeGroupEdsFileName)),
+				WatchRdsConfigFile(tt.rds),
+				WatchLdsConfigFile(filepath.Join(dir, NodeGroupLdsFileName)),
+			)
+			errs := w.Validate()
+			if len(errs) < 1 || strings.Contains(errs.Error(), tt.expect) != true {
+				tc.Errorf("expect '%s' actual %v", tt.expect, errs)
+			}
+		})
+	}
+}
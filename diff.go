@@ -0,0 +1,471 @@
+package xds
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+)
+
+const (
+	DiffAdded    string = "added"
+	DiffRemoved  string = "removed"
+	DiffModified string = "modified"
+
+	diffUnset string = "<unset>"
+)
+
+// ConfigFiles is a set of config files of a node group, empty Sds and Runtime are not served
+type ConfigFiles struct {
+	Cds     string `json:"cds"`
+	Eds     string `json:"eds"`
+	Rds     string `json:"rds"`
+	Lds     string `json:"lds"`
+	Sds     string `json:"sds"`
+	Runtime string `json:"runtime"`
+}
+
+// ConfigFilesFromDir returns the files of a node group directory
+func ConfigFilesFromDir(dir string) ConfigFiles {
+	g := newNodeGroupFromDir("", dir)
+	return ConfigFiles{
+		Cds:     g.cdsYaml,
+		Eds:     g.edsYaml,
+		Rds:     g.rdsYaml,
+		Lds:     g.ldsYaml,
+		Sds:     g.sdsYaml,
+		Runtime: g.runtimeYaml,
+	}
+}
+
+// ConfigContents is the yaml contents of proposed config files, empty ones are not changed
+type ConfigContents struct {
+	Cds     string `json:"cds"`
+	Eds     string `json:"eds"`
+	Rds     string `json:"rds"`
+	Lds     string `json:"lds"`
+	Runtime string `json:"runtime"`
+}
+
+// SnapshotDiff is the resource level difference of two snapshots
+type SnapshotDiff struct {
+	NodeGroup   string          `json:"node_group"`
+	FromVersion string          `json:"from_version"`
+	ToVersion   string          `json:"to_version"`
+	Resources   []*ResourceDiff `json:"resources"`
+}
+
+// ResourceDiff is an added, removed or modified resource.
+// Type is CDS, EDS, RDS(virtual host), Route(<vhost>/<route>), LDS, SDS or RTDS(runtime key)
+type ResourceDiff struct {
+	Type   string         `json:"type"`
+	Name   string         `json:"name"`
+	Action string         `json:"action"`
+	Fields []*FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a changed field of a modified resource, Path is the proto field path
+type FieldChange struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// DiffFiles compares the published snapshot of group with the proposed files,
+// empty proposed files are taken from the files of group
+func (w *WatchFile) DiffFiles(groupName string, proposed ConfigFiles) (*SnapshotDiff, error) {
+	published, files, err := w.publishedFiles(groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	// built without the lock, file reloads and NACK rollback are not blocked
+	next, err := w.buildFiles(groupName, mergeConfigFiles(files, proposed))
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotDiff(groupName, published, next), nil
+}
+
+// publishedFiles returns the published snapshot and the config files of group
+func (w *WatchFile) publishedFiles(groupName string) (*resourceState, ConfigFiles, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	g, ok := w.findGroup(groupName)
+	if ok != true {
+		return nil, ConfigFiles{}, fmt.Errorf("node group '%s' not found", groupName)
+	}
+	if g.published == nil {
+		return nil, ConfigFiles{}, fmt.Errorf("node group '%s' has no published snapshot", groupName)
+	}
+	return g.published, groupConfigFiles(g), nil
+}
+
+// DiffContents is DiffFiles with the yaml contents instead of files
+func (w *WatchFile) DiffContents(groupName string, proposed ConfigContents) (*SnapshotDiff, error) {
+	dir, err := ioutil.TempDir("", "xds-diff-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	files := ConfigFiles{}
+	write := func(name string, content string) (string, error) {
+		if content == "" {
+			return "", nil
+		}
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			return "", err
+		}
+		return path, nil
+	}
+	if files.Cds, err = write(NodeGroupCdsFileName, proposed.Cds); err != nil {
+		return nil, err
+	}
+	if files.Eds, err = write(NodeGroupEdsFileName, proposed.Eds); err != nil {
+		return nil, err
+	}
+	if files.Rds, err = write(NodeGroupRdsFileName, proposed.Rds); err != nil {
+		return nil, err
+	}
+	if files.Lds, err = write(NodeGroupLdsFileName, proposed.Lds); err != nil {
+		return nil, err
+	}
+	if files.Runtime, err = write(NodeGroupRuntimeFileName, proposed.Runtime); err != nil {
+		return nil, err
+	}
+
+	d, err := w.DiffFiles(groupName, files)
+	if errs, ok := err.(ValidationErrors); ok {
+		// temporary files are shown as "proposed/<name>.yaml"
+		for _, e := range errs {
+			if filepath.Dir(e.File) == dir {
+				e.File = filepath.Join("proposed", filepath.Base(e.File))
+			}
+		}
+	}
+	return d, err
+}
+
+// DiffFileSets compares the snapshots generated from two sets of files,
+// empty proposed files are taken from base
+func (w *WatchFile) DiffFileSets(base, proposed ConfigFiles) (*SnapshotDiff, error) {
+	prev, err := w.buildFiles("base", base)
+	if err != nil {
+		return nil, err
+	}
+	next, err := w.buildFiles("proposed", mergeConfigFiles(base, proposed))
+	if err != nil {
+		return nil, err
+	}
+	return newSnapshotDiff("", prev, next), nil
+}
+
+func (w *WatchFile) buildFiles(name string, files ConfigFiles) (*resourceState, error) {
+	g := newNodeGroup(name, files.Cds, files.Eds, files.Rds, files.Lds, files.Sds, files.Runtime)
+	r, errs := w.buildGroup(g)
+	if 0 < len(errs) {
+		return nil, errs
+	}
+	return r.state(), nil
+}
+
+func groupConfigFiles(g *nodeGroup) ConfigFiles {
+	return ConfigFiles{
+		Cds:     g.cdsYaml,
+		Eds:     g.edsYaml,
+		Rds:     g.rdsYaml,
+		Lds:     g.ldsYaml,
+		Sds:     g.sdsYaml,
+		Runtime: g.runtimeYaml,
+	}
+}
+
+func mergeConfigFiles(base, override ConfigFiles) ConfigFiles {
+	merged := base
+	if override.Cds != "" {
+		merged.Cds = override.Cds
+	}
+	if override.Eds != "" {
+		merged.Eds = override.Eds
+	}
+	if override.Rds != "" {
+		merged.Rds = override.Rds
+	}
+	if override.Lds != "" {
+		merged.Lds = override.Lds
+	}
+	if override.Sds != "" {
+		merged.Sds = override.Sds
+	}
+	if override.Runtime != "" {
+		merged.Runtime = override.Runtime
+	}
+	return merged
+}
+
+func newSnapshotDiff(name string, prev, next *resourceState) *SnapshotDiff {
+	resources := make([]*ResourceDiff, 0)
+	resources = append(resources, diffResources("CDS", clusterMessages(prev), clusterMessages(next), true)...)
+	resources = append(resources, diffResources("EDS", endpointMessages(prev), endpointMessages(next), true)...)
+	resources = append(resources, diffResources("RDS", virtualHostOnlyMessages(prev), virtualHostOnlyMessages(next), true)...)
+	resources = append(resources, diffResources("Route", routeMessages(prev), routeMessages(next), true)...)
+	resources = append(resources, diffResources("LDS", listenerMessages(prev), listenerMessages(next), true)...)
+	resources = append(resources, diffResources("SDS", secretMessages(prev), secretMessages(next), false)...)
+	resources = append(resources, diffResources("RTDS", runtimeMessages(prev), runtimeMessages(next), true)...)
+	return &SnapshotDiff{
+		NodeGroup:   name,
+		FromVersion: prev.version(),
+		ToVersion:   next.version(),
+		Resources:   resources,
+	}
+}
+
+// diffResources compares resources by name, fields of secrets are not shown (withFields = false)
+func diffResources(typ string, prev, next map[string]proto.Message, withFields bool) []*ResourceDiff {
+	names := make([]string, 0, len(prev)+len(next))
+	for name := range prev {
+		names = append(names, name)
+	}
+	for name := range next {
+		if _, ok := prev[name]; ok != true {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	diffs := make([]*ResourceDiff, 0)
+	for _, name := range names {
+		p, inPrev := prev[name]
+		n, inNext := next[name]
+		switch {
+		case inPrev != true:
+			diffs = append(diffs, &ResourceDiff{Type: typ, Name: name, Action: DiffAdded})
+		case inNext != true:
+			diffs = append(diffs, &ResourceDiff{Type: typ, Name: name, Action: DiffRemoved})
+		case proto.Equal(p, n) != true:
+			d := &ResourceDiff{Type: typ, Name: name, Action: DiffModified}
+			if withFields {
+				d.Fields = diffFields("", p.ProtoReflect(), n.ProtoReflect())
+			}
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// routeMessages returns the routes of each virtual host as "<vhost>/<route>",
+// routes with the same name (same prefix, different headers) are suffixed by "#<n>" in order
+func routeMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message)
+	for _, vh := range s.route.GetVirtualHosts() {
+		seen := make(map[string]int)
+		for _, r := range vh.GetRoutes() {
+			name := vh.GetName() + "/" + r.GetName()
+			if n := seen[r.GetName()]; 0 < n {
+				name += "#" + strconv.Itoa(n)
+			}
+			seen[r.GetName()] += 1
+			m[name] = r
+		}
+	}
+	return m
+}
+
+// diffFields returns the changed leaf fields of a and b (same message type)
+func diffFields(path string, a, b protoreflect.Message) []*FieldChange {
+	if a.Descriptor().FullName() == "google.protobuf.Any" {
+		if changes, ok := diffAny(path, a, b); ok {
+			return changes
+		}
+	}
+
+	changes := make([]*FieldChange, 0)
+	fields := a.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i += 1 {
+		fd := fields.Get(i)
+		fieldPath := joinFieldPath(path, string(fd.Name()))
+		hasA, hasB := a.Has(fd), b.Has(fd)
+		if hasA != true && hasB != true {
+			continue
+		}
+
+		switch {
+		case fd.IsList():
+			changes = append(changes, diffList(fieldPath, fd, a.Get(fd).List(), b.Get(fd).List())...)
+		case fd.IsMap():
+			changes = append(changes, diffMap(fieldPath, fd, a.Get(fd).Map(), b.Get(fd).Map())...)
+		case fd.Message() != nil:
+			if hasA != hasB {
+				changes = append(changes, &FieldChange{
+					Path: fieldPath,
+					From: formatField(fd, a.Get(fd), hasA),
+					To:   formatField(fd, b.Get(fd), hasB),
+				})
+				continue
+			}
+			changes = append(changes, diffFields(fieldPath, a.Get(fd).Message(), b.Get(fd).Message())...)
+		default:
+			if hasA != hasB || equalValue(a.Get(fd), b.Get(fd)) != true {
+				changes = append(changes, &FieldChange{
+					Path: fieldPath,
+					From: formatField(fd, a.Get(fd), hasA),
+					To:   formatField(fd, b.Get(fd), hasB),
+				})
+			}
+		}
+	}
+	return changes
+}
+
+// diffAny compares the packed messages, typed configs are shown by field
+func diffAny(path string, a, b protoreflect.Message) ([]*FieldChange, bool) {
+	anyA, okA := a.Interface().(*anypb.Any)
+	anyB, okB := b.Interface().(*anypb.Any)
+	if okA != true || okB != true || anyA.GetTypeUrl() != anyB.GetTypeUrl() {
+		return nil, false
+	}
+	msgA, err := anyA.UnmarshalNew()
+	if err != nil {
+		return nil, false
+	}
+	msgB, err := anyB.UnmarshalNew()
+	if err != nil {
+		return nil, false
+	}
+	return diffFields(path, msgA.ProtoReflect(), msgB.ProtoReflect()), true
+}
+
+func diffList(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.List) []*FieldChange {
+	changes := make([]*FieldChange, 0)
+	n := a.Len()
+	if n < b.Len() {
+		n = b.Len()
+	}
+	for i := 0; i < n; i += 1 {
+		itemPath := path + "[" + strconv.Itoa(i) + "]"
+		inA, inB := i < a.Len(), i < b.Len()
+		if inA && inB && fd.Message() != nil {
+			changes = append(changes, diffFields(itemPath, a.Get(i).Message(), b.Get(i).Message())...)
+			continue
+		}
+		if inA && inB && equalValue(a.Get(i), b.Get(i)) {
+			continue
+		}
+		c := &FieldChange{Path: itemPath, From: diffUnset, To: diffUnset}
+		if inA {
+			c.From = formatValue(fd, a.Get(i))
+		}
+		if inB {
+			c.To = formatValue(fd, b.Get(i))
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func diffMap(path string, fd protoreflect.FieldDescriptor, a, b protoreflect.Map) []*FieldChange {
+	keys := make([]protoreflect.MapKey, 0, a.Len()+b.Len())
+	a.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, k)
+		return true
+	})
+	b.Range(func(k protoreflect.MapKey, _ protoreflect.Value) bool {
+		if a.Has(k) != true {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	valueFd := fd.MapValue()
+	changes := make([]*FieldChange, 0)
+	for _, k := range keys {
+		itemPath := path + "[" + strconv.Quote(k.String()) + "]"
+		inA, inB := a.Has(k), b.Has(k)
+		if inA && inB && valueFd.Message() != nil {
+			changes = append(changes, diffFields(itemPath, a.Get(k).Message(), b.Get(k).Message())...)
+			continue
+		}
+		if inA && inB && equalValue(a.Get(k), b.Get(k)) {
+			continue
+		}
+		c := &FieldChange{Path: itemPath, From: diffUnset, To: diffUnset}
+		if inA {
+			c.From = formatValue(valueFd, a.Get(k))
+		}
+		if inB {
+			c.To = formatValue(valueFd, b.Get(k))
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+func equalValue(a, b protoreflect.Value) bool {
+	if x, ok := a.Interface().([]byte); ok {
+		y, _ := b.Interface().([]byte)
+		return bytes.Equal(x, y)
+	}
+	return a.Interface() == b.Interface()
+}
+
+func formatField(fd protoreflect.FieldDescriptor, v protoreflect.Value, has bool) string {
+	if has != true {
+		return diffUnset
+	}
+	return formatValue(fd, v)
+}
+
+func formatValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) string {
+	switch {
+	case fd.Message() != nil:
+		data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(v.Message().Interface())
+		if err != nil {
+			return err.Error()
+		}
+		return string(data)
+	case fd.Enum() != nil:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return strconv.Itoa(int(v.Enum()))
+	case fd.Kind() == protoreflect.StringKind:
+		return strconv.Quote(v.String())
+	case fd.Kind() == protoreflect.BytesKind:
+		return fmt.Sprintf("<%d bytes>", len(v.Bytes()))
+	}
+	return v.String()
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// virtualHostOnlyMessages returns the virtual hosts without routes, routes are compared on their own
+func virtualHostOnlyMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message)
+	for _, vh := range s.route.GetVirtualHosts() {
+		c := proto.Clone(vh).(*routev3.VirtualHost)
+		c.Routes = nil
+		m[c.GetName()] = c
+	}
+	return m
+}
@@ -2,10 +2,10 @@ package xds
 
 import (
 	"log"
-	"strconv"
-	"sync/atomic"
+	"sort"
 
 	"github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/proto"
 
 	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
@@ -45,11 +45,6 @@ func initEdsOpt(opt *edsOpt) {
 type endpointDiscoveryService struct {
 	opt       *edsOpt
 	xdsConfig *corev3.ConfigSource
-	version   uint64
-}
-
-func (e *endpointDiscoveryService) increVersion() uint64 {
-	return atomic.AddUint64(&e.version, 1)
 }
 
 func (e *endpointDiscoveryService) instanceEndpoint(instance EDSInstanceConfig) *endpointv3.Endpoint {
@@ -119,16 +114,18 @@ func (e *endpointDiscoveryService) lbLocalityEndpoint(region string, zone string
 
 func (e *endpointDiscoveryService) lbLocalityEndpointsByRegion(region string, instances []EDSInstanceConfig) []*endpointv3.LocalityLbEndpoints {
 	lbEndpoints := make([]*endpointv3.LocalityLbEndpoints, 0, len(instances))
-	for zone, ins := range instancesByZone(instances) {
-		lbEndpoints = append(lbEndpoints, e.lbLocalityEndpoint(region, zone, ins))
+	byZone := instancesByZone(instances)
+	for _, zone := range sortedInstanceKeys(byZone) {
+		lbEndpoints = append(lbEndpoints, e.lbLocalityEndpoint(region, zone, byZone[zone]))
 	}
 	return lbEndpoints
 }
 
 func (e *endpointDiscoveryService) lbLocalityEnpoints(instances []EDSInstanceConfig) []*endpointv3.LocalityLbEndpoints {
 	lbEndpoints := make([]*endpointv3.LocalityLbEndpoints, 0, len(instances))
-	for region, ins := range instancesByRegion(instances) {
-		for _, lbEndpoint := range e.lbLocalityEndpointsByRegion(region, ins) {
+	byRegion := instancesByRegion(instances)
+	for _, region := range sortedInstanceKeys(byRegion) {
+		for _, lbEndpoint := range e.lbLocalityEndpointsByRegion(region, byRegion[region]) {
 			lbEndpoints = append(lbEndpoints, lbEndpoint)
 		}
 	}
@@ -172,8 +169,16 @@ func (e *endpointDiscoveryService) edsEndpoints(configs []EDSConfig) []*endpoint
 }
 
 func (e *endpointDiscoveryService) create(configs []EDSConfig) (string, []*endpointv3.ClusterLoadAssignment, error) {
-	version := strconv.FormatUint(e.increVersion(), 10)
-	return version, e.edsEndpoints(configs), nil
+	endpoints := e.edsEndpoints(configs)
+	resources := make([]proto.Message, len(endpoints))
+	for i, endpoint := range endpoints {
+		resources[i] = endpoint
+	}
+	version, err := resourceVersion(resources...)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, endpoints, nil
 }
 
 func newEndpointDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...edsOptFunc) *endpointDiscoveryService {
@@ -186,7 +191,6 @@ func newEndpointDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...edsOpt
 	return &endpointDiscoveryService{
 		opt:       opt,
 		xdsConfig: xdsConfig,
-		version:   uint64(0),
 	}
 }
 
@@ -213,3 +217,13 @@ func instancesByZone(instances []EDSInstanceConfig) map[string][]EDSInstanceConf
 	}
 	return m
 }
+
+// stable order of locality, resource hash (delta xDS) depends on it
+func sortedInstanceKeys(m map[string][]EDSInstanceConfig) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
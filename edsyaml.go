@@ -5,19 +5,19 @@ import (
 )
 
 type EDSConfig struct {
-	ClusterName     string              `yaml:"name"             validate:"required"`
-	BalancingPolicy string              `yaml:"balancing-policy" validate:"required"`
-	Instances       []EDSInstanceConfig `yaml:"instances"        validate:"required"`
+	ClusterName     string              `yaml:"name"             json:"name"             validate:"required"`
+	BalancingPolicy string              `yaml:"balancing-policy" json:"balancing-policy" validate:"required"`
+	Instances       []EDSInstanceConfig `yaml:"instances"        json:"instances"        validate:"required"`
 }
 
 type EDSInstanceConfig struct {
-	InstanceName string `yaml:"instance-name"  validate:"required"`
-	IP           string `yaml:"ip"             validate:"required,ip"`
-	Port         uint32 `yaml:"port"           validate:"required,gte=1,lte=65535"`
-	Region       string `yaml:"region"         validate:"required"`
-	Zone         string `yaml:"zone"           validate:"zone"`
-	Protocol     string `yaml:"protocol"       validate:"required"`
-	Weight       uint32 `yaml:"weight"`
+	InstanceName string `yaml:"instance-name"  json:"instance-name"  validate:"required"`
+	IP           string `yaml:"ip"             json:"ip"             validate:"required,ip"`
+	Port         uint32 `yaml:"port"           json:"port"           validate:"required,gte=1,lte=65535"`
+	Region       string `yaml:"region"         json:"region"         validate:"required"`
+	Zone         string `yaml:"zone"           json:"zone"           validate:"zone"`
+	Protocol     string `yaml:"protocol"       json:"protocol"       validate:"required"`
+	Weight       uint32 `yaml:"weight" json:"weight"`
 }
 
 func (c EDSInstanceConfig) Address() *corev3.Address {
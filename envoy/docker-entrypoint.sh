@@ -1,7 +1,11 @@
 #!/usr/bin/dumb-init /bin/sh
 set -e
 
-cp /etc/envoy/envoy.yaml /envoy/envoy.yaml
+if [ "${ENVOY_XDS_ADS:-0}" = "1" ]; then
+  cp /etc/envoy/envoy-ads.yaml /envoy/envoy.yaml
+else
+  cp /etc/envoy/envoy.yaml /envoy/envoy.yaml
+fi
 
 cluster=${ENVOY_XDS_CLUSTER:-"example0"}
 node=${ENVOY_XDS_NODE_ID:-"node0"}
@@ -13,6 +17,24 @@ alshost=${ENVOY_ALS_HOST:-"127.0.0.1"}
 alsport=${ENVOY_ALS_PORT:-"5001"}
 adminhost=${ENVOY_ADMIN_LISTEN_HOST:-"127.0.0.1"}
 adminport=${ENVOY_ADMIN_LISTEN_PORT:-"9800"}
+apitype="GRPC"
+if [ "${ENVOY_XDS_DELTA:-0}" = "1" ]; then
+  apitype="DELTA_GRPC"
+fi
+
+# upstream TLS to xds_cluster and als_cluster
+transportsocket=""
+if [ -n "${ENVOY_XDS_TLS_CA}" ]; then
+  tlssni=""
+  if [ -n "${ENVOY_XDS_TLS_SNI}" ]; then
+    tlssni="sni: ${ENVOY_XDS_TLS_SNI}, "
+  fi
+  tlscerts=""
+  if [ -n "${ENVOY_XDS_TLS_CERT}" ]; then
+    tlscerts="tls_certificates: [ { certificate_chain: { filename: ${ENVOY_XDS_TLS_CERT} }, private_key: { filename: ${ENVOY_XDS_TLS_KEY} } } ], "
+  fi
+  transportsocket="transport_socket: { name: envoy.transport_sockets.tls, typed_config: { \"@type\": type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext, ${tlssni}common_tls_context: { ${tlscerts}validation_context: { trusted_ca: { filename: ${ENVOY_XDS_TLS_CA} } } } } }"
+fi
 
 sed -i -e "s/@ENVOY_XDS_CLUSTER@/$cluster/" /envoy/envoy.yaml
 sed -i -e "s/@ENVOY_XDS_NODE_ID@/$node/" /envoy/envoy.yaml
@@ -24,5 +46,7 @@ sed -i -e "s/@ENVOY_ALS_HOST@/$alshost/" /envoy/envoy.yaml
 sed -i -e "s/@ENVOY_ALS_PORT@/$alsport/" /envoy/envoy.yaml
 sed -i -e "s/@ENVOY_ADMIN_LISTEN_HOST@/$adminhost/" /envoy/envoy.yaml
 sed -i -e "s/@ENVOY_ADMIN_LISTEN_PORT@/$adminport/" /envoy/envoy.yaml
+sed -i -e "s/@ENVOY_XDS_API_TYPE@/$apitype/" /envoy/envoy.yaml
+sed -i -e "s|# @ENVOY_XDS_TLS_TRANSPORT_SOCKET@|$transportsocket|" /envoy/envoy.yaml
 
 exec envoy -c /envoy/envoy.yaml "$@"
@@ -0,0 +1,435 @@
+package xds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	WatchModeNotify  string = "fsnotify"
+	WatchModePolling string = "polling"
+
+	WatchStatusOk   string = "OK"
+	WatchStatusLost string = "LOST"
+
+	defaultWatchDebounce     time.Duration = 100 * time.Millisecond
+	defaultWatchPollInterval time.Duration = 2 * time.Second
+	watchRetryMinInterval    time.Duration = 500 * time.Millisecond
+	watchRetryMaxInterval    time.Duration = 30 * time.Second
+	watchMaxCoalesce         int           = 10 // times of debounce
+)
+
+// WatchStatus is the state of config file watching.
+// Status is LOST while fsnotify is unavailable or a directory can not be watched,
+// changes are found by polling meanwhile and watching is retried with backoff
+type WatchStatus struct {
+	Mode      string    `json:"mode"`
+	Status    string    `json:"status"`
+	Dirs      []string  `json:"dirs"`
+	Missing   []string  `json:"missing"`
+	LastError string    `json:"last_error"`
+	ErrorAt   time.Time `json:"error_at"`
+	ScannedAt time.Time `json:"scanned_at"`
+}
+
+type fileWatchOpt struct {
+	polling      bool
+	pollInterval time.Duration
+	debounce     time.Duration
+	observeLost  func(lost bool) // optional
+}
+
+func defaultFileWatchOpt() fileWatchOpt {
+	return fileWatchOpt{
+		polling:      false,
+		pollInterval: defaultWatchPollInterval,
+		debounce:     defaultWatchDebounce,
+	}
+}
+
+// fileWatcher finds changed files(config files, certificates) by their content hash.
+// fsnotify watches the parent directories of the files, so that atomic rename saves and
+// kubernetes ConfigMap/Secret ..data symlink swaps are noticed, and a burst of events triggers a single scan
+type fileWatcher struct {
+	name      string
+	opt       fileWatchOpt
+	paths     func() []string
+	change    func(paths ...string)
+	notify    *fsnotify.Watcher // nil while polling
+	dirs      map[string]struct{}
+	missing   map[string]error
+	checksums map[string]string
+	mutex     *sync.Mutex
+	status    WatchStatus
+}
+
+// Status returns a copy of the current state
+func (fw *fileWatcher) Status() WatchStatus {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	st := fw.status
+	st.Dirs = append([]string{}, fw.status.Dirs...)
+	st.Missing = append([]string{}, fw.status.Missing...)
+	return st
+}
+
+// seed records the checksums of the files loaded before start,
+// so that the first scan notices the changes made since then instead of taking them as first seen
+func (fw *fileWatcher) seed(checksums map[string]string) {
+	for path, sum := range checksums {
+		fw.checksums[path] = sum
+	}
+}
+
+func (fw *fileWatcher) start(ctx context.Context) {
+	if fw.opt.polling != true {
+		fw.openNotify()
+	}
+	fw.syncDirs()
+	fw.scan()
+
+	go fw.loop(ctx)
+}
+
+func (fw *fileWatcher) openNotify() {
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		fw.setLost(fmt.Errorf("fsnotify unavailable, fallback to polling: %s", err.Error()))
+		return
+	}
+	fw.notify = notify
+	fw.dirs = make(map[string]struct{})
+}
+
+func (fw *fileWatcher) closeNotify() {
+	if fw.notify != nil {
+		fw.notify.Close()
+	}
+	fw.notify = nil
+	fw.dirs = make(map[string]struct{})
+}
+
+func (fw *fileWatcher) loop(ctx context.Context) {
+	defer log.Printf("info: stop %s file watching", fw.name)
+	defer fw.closeNotify()
+
+	pollTicker := time.NewTicker(fw.opt.pollInterval)
+	defer pollTicker.Stop()
+
+	var debounce, retry <-chan time.Time
+	var burstStart time.Time
+	backoff := watchRetryMinInterval
+	delayScan := func() {
+		if debounce == nil {
+			burstStart = time.Now()
+		}
+		debounce = time.After(fw.debounceDelay(burstStart))
+	}
+	for {
+		var events <-chan fsnotify.Event
+		var errors <-chan error
+		if fw.notify != nil {
+			events, errors = fw.notify.Events, fw.notify.Errors
+		}
+		var poll <-chan time.Time
+		if fw.notify == nil || 0 < len(fw.missing) {
+			poll = pollTicker.C
+		}
+		if retry == nil && fw.lost() {
+			retry = time.After(backoff)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+
+		case err, ok := <-errors:
+			if ok != true {
+				fw.closeNotify()
+				fw.setLost(fmt.Errorf("fsnotify closed, fallback to polling"))
+				continue
+			}
+			// events may be dropped(overflow), scan everything
+			fw.setError(err)
+			delayScan()
+
+		case evt, ok := <-events:
+			if ok != true {
+				fw.closeNotify()
+				fw.setLost(fmt.Errorf("fsnotify closed, fallback to polling"))
+				continue
+			}
+			if evt.Op == fsnotify.Chmod {
+				continue
+			}
+			log.Printf("debug: %s file event: %s(%s)", fw.name, evt.Name, evt.Op)
+			if evt.Op&(fsnotify.Remove|fsnotify.Rename) != 0 {
+				fw.unwatchDir(evt.Name)
+			}
+			delayScan()
+
+		case <-debounce:
+			debounce = nil
+			fw.syncDirs()
+			fw.scan()
+
+		case <-poll:
+			fw.syncDirs()
+			fw.scan()
+
+		case <-retry:
+			retry = nil
+			if fw.notify == nil && fw.opt.polling != true {
+				fw.openNotify()
+			}
+			fw.syncDirs()
+			fw.scan()
+			if fw.lost() {
+				backoff *= 2
+				if watchRetryMaxInterval < backoff {
+					backoff = watchRetryMaxInterval
+				}
+				log.Printf("warn: %s file watch is still lost, retry in %s", fw.name, backoff)
+				continue
+			}
+			backoff = watchRetryMinInterval
+		}
+	}
+}
+
+// debounceDelay waits the events to settle for the debounce interval, changes arriving within it
+// are coalesced into a single scan, a burst is not delayed longer than watchMaxCoalesce times of it
+func (fw *fileWatcher) debounceDelay(burstStart time.Time) time.Duration {
+	delay := fw.opt.debounce
+	deadline := burstStart.Add(time.Duration(watchMaxCoalesce) * fw.opt.debounce)
+	if remain := time.Until(deadline); remain < delay {
+		delay = remain
+	}
+	return delay
+}
+
+func (fw *fileWatcher) lost() bool {
+	if fw.notify == nil {
+		return fw.opt.polling != true
+	}
+	return 0 < len(fw.missing)
+}
+
+// syncDirs adds the directories of the current config files to fsnotify and removes the unused ones
+func (fw *fileWatcher) syncDirs() {
+	if fw.notify == nil {
+		fw.updateStatus(nil)
+		return
+	}
+
+	wanted := fw.watchDirs()
+	missing := make(map[string]error)
+	for _, dir := range wanted {
+		if _, ok := fw.dirs[dir]; ok {
+			continue
+		}
+		if err := fw.notify.Add(dir); err != nil {
+			missing[dir] = err
+			continue
+		}
+		fw.dirs[dir] = struct{}{}
+	}
+	for dir := range fw.dirs {
+		if containsString(wanted, dir) != true {
+			fw.unwatchDir(dir)
+		}
+	}
+	fw.missing = missing
+	fw.updateStatus(wanted)
+}
+
+func (fw *fileWatcher) unwatchDir(dir string) {
+	if _, ok := fw.dirs[dir]; ok != true {
+		return
+	}
+	delete(fw.dirs, dir)
+	if fw.notify != nil {
+		fw.notify.Remove(dir) // already removed by fsnotify if dir is deleted
+	}
+}
+
+// watchDirs returns the parent directories of the files, conf.d directories
+// and the directories of symlink targets
+func (fw *fileWatcher) watchDirs() []string {
+	seen := make(map[string]struct{})
+	add := func(dir string) {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		seen[dir] = struct{}{}
+	}
+	for _, path := range fw.paths() {
+		add(filepath.Dir(path))
+		if isConfDir(path) {
+			add(path)
+		}
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && equalPath(resolved, path) != true {
+			add(filepath.Dir(resolved))
+			if isConfDir(resolved) {
+				add(resolved)
+			}
+		}
+	}
+
+	dirs := make([]string, 0, len(seen))
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// scan notifies the files whose content hash is changed since the last scan,
+// files changed together are notified at once(applied as a single transaction by WatchFile)
+func (fw *fileWatcher) scan() {
+	changed := make([]string, 0)
+	for _, path := range fw.paths() {
+		sum := pathChecksum(path)
+		prev, ok := fw.checksums[path]
+		fw.checksums[path] = sum
+		if ok != true || prev == sum {
+			continue // first seen(not seeded, e.g. referenced by a new sds.yaml) or unchanged
+		}
+		log.Printf("info: %s file changed: %s", fw.name, path)
+		changed = append(changed, path)
+	}
+	if 0 < len(changed) {
+		fw.change(changed...)
+	}
+
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.status.ScannedAt = time.Now()
+}
+
+func (fw *fileWatcher) setError(err error) {
+	log.Printf("error: %s file watch error: %s", fw.name, err.Error())
+
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	fw.status.LastError = err.Error()
+	fw.status.ErrorAt = time.Now()
+}
+
+func (fw *fileWatcher) setLost(err error) {
+	fw.setError(err)
+	fw.updateStatus(nil)
+}
+
+func (fw *fileWatcher) updateStatus(dirs []string) {
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+
+	missing := make([]string, 0, len(fw.missing))
+	for dir, err := range fw.missing {
+		missing = append(missing, dir)
+		fw.status.LastError = fmt.Sprintf("failed add watch(%s) to fsnotify: %s", dir, err.Error())
+		fw.status.ErrorAt = time.Now()
+	}
+	sort.Strings(missing)
+
+	status, mode := WatchStatusOk, WatchModeNotify
+	if fw.notify == nil {
+		mode = WatchModePolling
+	}
+	if fw.lost() {
+		status = WatchStatusLost
+	}
+	if fw.status.Status != status {
+		if status == WatchStatusLost {
+			log.Printf("error: %s file watch lost, polling every %s: %s", fw.name, fw.opt.pollInterval, fw.status.LastError)
+		} else if fw.status.Status != "" {
+			log.Printf("info: %s file watch recovered(%s)", fw.name, mode)
+		}
+	}
+	if fw.opt.observeLost != nil {
+		fw.opt.observeLost(status == WatchStatusLost)
+	}
+
+	fw.status.Mode = mode
+	fw.status.Status = status
+	fw.status.Missing = missing
+	if dirs != nil {
+		fw.status.Dirs = dirs
+	}
+	if fw.notify == nil {
+		fw.status.Dirs = []string{}
+	}
+}
+
+// newFileWatcher watches the files(or conf.d directories) returned by paths, change is called with the changed ones
+func newFileWatcher(name string, opt fileWatchOpt, paths func() []string, change func(paths ...string)) *fileWatcher {
+	return &fileWatcher{
+		name:      name,
+		opt:       opt,
+		paths:     paths,
+		change:    change,
+		notify:    nil,
+		dirs:      make(map[string]struct{}),
+		missing:   make(map[string]error),
+		checksums: make(map[string]string),
+		mutex:     new(sync.Mutex),
+		status: WatchStatus{
+			Dirs:    []string{},
+			Missing: []string{},
+		},
+	}
+}
+
+// pathChecksum is the hash of the names and contents of the files of path(a file or a conf.d directory),
+// symlinks are followed, a missing file is hashed as missing
+func pathChecksum(path string) string {
+	h := sha256.New()
+	files, err := configFiles(path)
+	if err != nil {
+		h.Write([]byte("error:" + err.Error()))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	for _, file := range files {
+		h.Write([]byte(file))
+		h.Write([]byte{0})
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			h.Write([]byte("missing"))
+		} else {
+			h.Write(data)
+		}
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func pathChecksums(paths []string) map[string]string {
+	checksums := make(map[string]string, len(paths))
+	for _, path := range paths {
+		checksums[path] = pathChecksum(path)
+	}
+	return checksums
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
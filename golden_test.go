@@ -0,0 +1,139 @@
+package xds
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	updateGolden = flag.Bool("update", false, "update golden files in testdata/golden")
+)
+
+type validateAller interface {
+	ValidateAll() error
+}
+
+// TestGoldenGenerators renders the sample yaml files with each generator and compares them
+// with testdata/golden/<case>/<type>.json, run `go test -run TestGolden -update` to accept changes
+func TestGoldenGenerators(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  string
+		ads  bool
+	}{
+		{"example", ".", false},
+		{"example-ads", ".", true},
+		{"variants", filepath.Join("testdata", "variants"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(tc *testing.T) {
+			w := NewWatchFile(context.Background(), WatchAds(tt.ads))
+			golden := filepath.Join("testdata", "golden", tt.name)
+
+			tc.Run("cds", func(tb *testing.T) {
+				configs, err := w.loadCds(filepath.Join(tt.dir, NodeGroupCdsFileName))
+				if err != nil {
+					tb.Fatalf("load: %s", err.Error())
+				}
+				msgs := make([]proto.Message, len(configs))
+				for i, config := range configs {
+					msgs[i] = w.cds.clusterConfig(config)
+				}
+				testGolden(tb, filepath.Join(golden, "cds.json"), msgs)
+			})
+			tc.Run("eds", func(tb *testing.T) {
+				configs, err := w.loadEds(filepath.Join(tt.dir, NodeGroupEdsFileName))
+				if err != nil {
+					tb.Fatalf("load: %s", err.Error())
+				}
+				msgs := make([]proto.Message, len(configs))
+				for i, config := range configs {
+					msgs[i] = w.eds.clusterLoadAssignment(config.ClusterName, config.BalancingPolicy, config.Instances)
+				}
+				testGolden(tb, filepath.Join(golden, "eds.json"), msgs)
+			})
+			tc.Run("rds", func(tb *testing.T) {
+				configs, err := w.loadRds(filepath.Join(tt.dir, NodeGroupRdsFileName))
+				if err != nil {
+					tb.Fatalf("load: %s", err.Error())
+				}
+				testGolden(tb, filepath.Join(golden, "rds.json"), []proto.Message{w.rds.routeConfiguration(configs)})
+			})
+			tc.Run("lds", func(tb *testing.T) {
+				config, err := w.loadLds(filepath.Join(tt.dir, NodeGroupLdsFileName))
+				if err != nil {
+					tb.Fatalf("load: %s", err.Error())
+				}
+				_, listener, err := w.lds.create(config)
+				if err != nil {
+					tb.Fatalf("create: %s", err.Error())
+				}
+				testGolden(tb, filepath.Join(golden, "lds.json"), []proto.Message{listener})
+			})
+		})
+	}
+}
+
+// testGolden checks the protoc-gen-validate rules of msgs and compares their protojson with the golden file
+func testGolden(t *testing.T, file string, msgs []proto.Message) {
+	t.Helper()
+
+	for _, msg := range msgs {
+		if err := msg.(validateAller).ValidateAll(); err != nil {
+			t.Errorf("validate %s: %s", msg.ProtoReflect().Descriptor().FullName(), err.Error())
+		}
+	}
+
+	raws, err := renderMessages(msgs...)
+	if err != nil {
+		t.Fatalf("marshal: %s", err.Error())
+	}
+	// re-encoding normalizes the whitespaces protojson randomizes
+	data, err := json.MarshalIndent(raws, "", "  ")
+	if err != nil {
+		t.Fatalf("marshal: %s", err.Error())
+	}
+	data = append(data, '\n')
+
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatalf("mkdir: %s", err.Error())
+		}
+		if err := ioutil.WriteFile(file, data, 0644); err != nil {
+			t.Fatalf("write golden: %s", err.Error())
+		}
+		t.Logf("updated %s", file)
+		return
+	}
+
+	expect, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read golden: %s (run `go test -run TestGolden -update` to create it)", err.Error())
+	}
+	if string(expect) == string(data) {
+		return
+	}
+
+	expectLines := strings.Split(string(expect), "\n")
+	actualLines := strings.Split(string(data), "\n")
+	for i := 0; i < len(expectLines) || i < len(actualLines); i += 1 {
+		e, a := "", ""
+		if i < len(expectLines) {
+			e = expectLines[i]
+		}
+		if i < len(actualLines) {
+			a = actualLines[i]
+		}
+		if e != a {
+			t.Fatalf("%s:%d differs (run `go test -run TestGolden -update` if intended)\nexpect: %s\nactual: %s", file, i+1, e, a)
+		}
+	}
+}
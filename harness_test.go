@@ -0,0 +1,423 @@
+package xds
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	alsv3 "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+	discoverygrpcv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+const (
+	testRecvTimeout    time.Duration = 5 * time.Second
+	testNoRecvTimeout  time.Duration = 500 * time.Millisecond
+	testStartupTimeout time.Duration = 5 * time.Second
+)
+
+var (
+	// config files of the repository root are the fixtures of the default node group
+	testConfigFiles = []string{
+		NodeGroupCdsFileName,
+		NodeGroupEdsFileName,
+		NodeGroupRdsFileName,
+		NodeGroupLdsFileName,
+		NodeGroupRuntimeFileName,
+	}
+)
+
+// testControlPlane runs WatchFile and server in process, on ephemeral ports with config files in a temp dir
+type testControlPlane struct {
+	t          *testing.T
+	dir        string
+	xdsAddr    string
+	alsAddr    string
+	watchOpts  []watchOptFunc
+	serverOpts []serverOptFunc
+	watch      *WatchFile
+	server     *server
+	acclog     *syncBuffer
+	conn       *grpc.ClientConn
+}
+
+// newTestControlPlane copies the config files to a temp dir, files can be changed before start
+func newTestControlPlane(t *testing.T) *testControlPlane {
+	t.Helper()
+
+	dir := t.TempDir()
+	for _, name := range testConfigFiles {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read fixture %s: %s", name, err.Error())
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatalf("write fixture %s: %s", name, err.Error())
+		}
+	}
+	return &testControlPlane{
+		t:      t,
+		dir:    dir,
+		acclog: new(syncBuffer),
+	}
+}
+
+func (p *testControlPlane) path(name string) string {
+	return filepath.Join(p.dir, name)
+}
+
+// start loads the files of the default node group(and watchOpts), starts the servers and connects to xds
+func (p *testControlPlane) start() {
+	p.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p.xdsAddr = testFreeAddr(p.t)
+	p.alsAddr = testFreeAddr(p.t)
+
+	watchOpts := append([]watchOptFunc{
+		WatchCdsConfigFile(p.path(NodeGroupCdsFileName)),
+		WatchEdsConfigFile(p.path(NodeGroupEdsFileName)),
+		WatchRdsConfigFile(p.path(NodeGroupRdsFileName)),
+		WatchLdsConfigFile(p.path(NodeGroupLdsFileName)),
+		WatchRuntimeConfigFile(p.path(NodeGroupRuntimeFileName)),
+	}, p.watchOpts...)
+	p.watch = NewWatchFile(ctx, watchOpts...)
+
+	serverOpts := append([]serverOptFunc{
+		XdsListenAddr(p.xdsAddr),
+		AlsListenAddr(p.alsAddr),
+		ServerWatchFile(p.watch),
+		AccessLogOutput(p.acclog),
+		ShutdownTimeout(time.Second),
+	}, p.serverOpts...)
+	p.server = NewServer(ctx, p.watch.Cache(), serverOpts...)
+
+	if err := p.watch.InitialLoad(); err != nil {
+		cancel()
+		p.t.Fatalf("initial load: %s", err.Error())
+	}
+	if err := p.watch.Watch(ctx); err != nil {
+		cancel()
+		p.t.Fatalf("watch: %s", err.Error())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.server.Start()
+	}()
+	p.t.Cleanup(func() {
+		cancel()
+		if p.conn != nil {
+			p.conn.Close()
+		}
+		if err := p.server.Stop(); err != nil {
+			p.t.Errorf("stop: %s", err.Error())
+		}
+		<-done
+	})
+
+	testWaitListen(p.t, p.xdsAddr)
+	testWaitListen(p.t, p.alsAddr)
+
+	conn, err := grpc.Dial(p.xdsAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		p.t.Fatalf("dial %s: %s", p.xdsAddr, err.Error())
+	}
+	p.conn = conn
+}
+
+// writeFile replaces the file in place(the same as editors that truncate and write)
+func (p *testControlPlane) writeFile(name string, content string) {
+	p.t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(p.path(name)), 0755); err != nil {
+		p.t.Fatalf("mkdir %s: %s", name, err.Error())
+	}
+	if err := ioutil.WriteFile(p.path(name), []byte(content), 0644); err != nil {
+		p.t.Fatalf("write %s: %s", name, err.Error())
+	}
+}
+
+func (p *testControlPlane) readFile(name string) string {
+	p.t.Helper()
+
+	data, err := ioutil.ReadFile(p.path(name))
+	if err != nil {
+		p.t.Fatalf("read %s: %s", name, err.Error())
+	}
+	return string(data)
+}
+
+// replaceFile writes the file with old replaced by new, old must exist
+func (p *testControlPlane) replaceFile(name string, old, new string) {
+	p.t.Helper()
+
+	content := p.readFile(name)
+	if strings.Contains(content, old) != true {
+		p.t.Fatalf("%s does not contain '%s'", name, old)
+	}
+	p.writeFile(name, strings.Replace(content, old, new, -1))
+}
+
+func (p *testControlPlane) removeFile(name string) {
+	p.t.Helper()
+
+	if err := os.Remove(p.path(name)); err != nil {
+		p.t.Fatalf("remove %s: %s", name, err.Error())
+	}
+}
+
+// snapshotVersion is the version of typeURL published to group
+func (p *testControlPlane) snapshotVersion(group string, typeURL string) string {
+	p.t.Helper()
+
+	snapshot, err := p.watch.Cache().(cachev3.SnapshotCache).GetSnapshot(group)
+	if err != nil {
+		p.t.Fatalf("snapshot %s: %s", group, err.Error())
+	}
+	return snapshot.GetVersion(typeURL)
+}
+
+// subscribe opens a SotW stream of typeURL as node and sends the initial request
+func (p *testControlPlane) subscribe(typeURL string, node *corev3.Node) *testSotwStream {
+	p.t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.t.Cleanup(cancel)
+
+	stream, err := openSotwStream(ctx, p.conn, typeURL)
+	if err != nil {
+		p.t.Fatalf("open %s stream: %s", typeName(typeURL), err.Error())
+	}
+	s := &testSotwStream{
+		t:         p.t,
+		stream:    stream,
+		node:      node,
+		typeURL:   typeURL,
+		responses: make(chan *discoverygrpcv3.DiscoveryResponse, 16),
+	}
+	go s.recvLoop()
+
+	s.send(&discoverygrpcv3.DiscoveryRequest{Node: node, TypeUrl: typeURL})
+	return s
+}
+
+// sendAccessLog sends msg over a ALS stream and waits for the server to close it
+func (p *testControlPlane) sendAccessLog(msg *alsv3.StreamAccessLogsMessage) {
+	p.t.Helper()
+
+	conn, err := grpc.Dial(p.alsAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		p.t.Fatalf("dial %s: %s", p.alsAddr, err.Error())
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testRecvTimeout)
+	defer cancel()
+
+	stream, err := alsv3.NewAccessLogServiceClient(conn).StreamAccessLogs(ctx)
+	if err != nil {
+		p.t.Fatalf("open als stream: %s", err.Error())
+	}
+	if err := stream.Send(msg); err != nil {
+		p.t.Fatalf("send access log: %s", err.Error())
+	}
+	// the server closes the stream without a response after the first message
+	if _, err := stream.CloseAndRecv(); err != nil && err != io.EOF {
+		p.t.Fatalf("close als stream: %s", err.Error())
+	}
+}
+
+// accessLog flushes and returns the access logs written so far
+func (p *testControlPlane) accessLog() string {
+	p.t.Helper()
+
+	if err := p.server.acclog.Flush(); err != nil {
+		p.t.Fatalf("flush access log: %s", err.Error())
+	}
+	return p.acclog.String()
+}
+
+// testSotwStream is a SotW subscription of a single type
+type testSotwStream struct {
+	t         *testing.T
+	stream    sotwStream
+	node      *corev3.Node
+	typeURL   string
+	responses chan *discoverygrpcv3.DiscoveryResponse
+	mutex     sync.Mutex
+	err       error
+}
+
+func (s *testSotwStream) recvLoop() {
+	defer close(s.responses)
+	for {
+		res, err := s.stream.Recv()
+		if err != nil {
+			s.mutex.Lock()
+			s.err = err
+			s.mutex.Unlock()
+			return
+		}
+		s.responses <- res
+	}
+}
+
+func (s *testSotwStream) send(req *discoverygrpcv3.DiscoveryRequest) {
+	s.t.Helper()
+
+	if err := s.stream.Send(req); err != nil {
+		s.t.Fatalf("send %s request: %s", typeName(s.typeURL), err.Error())
+	}
+}
+
+// recv waits the next response
+func (s *testSotwStream) recv() *discoverygrpcv3.DiscoveryResponse {
+	s.t.Helper()
+
+	select {
+	case res, ok := <-s.responses:
+		if ok != true {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			s.t.Fatalf("%s stream closed: %v", typeName(s.typeURL), s.err)
+		}
+		return res
+	case <-time.After(testRecvTimeout):
+		s.t.Fatalf("%s response timeout", typeName(s.typeURL))
+	}
+	return nil
+}
+
+// recvAck waits the next response and ACKs it
+func (s *testSotwStream) recvAck() *discoverygrpcv3.DiscoveryResponse {
+	s.t.Helper()
+
+	res := s.recv()
+	s.send(&discoverygrpcv3.DiscoveryRequest{
+		Node:          s.node,
+		TypeUrl:       s.typeURL,
+		VersionInfo:   res.GetVersionInfo(),
+		ResponseNonce: res.GetNonce(),
+	})
+	return res
+}
+
+// nack rejects res, ackedVersion is the version the node is running
+func (s *testSotwStream) nack(res *discoverygrpcv3.DiscoveryResponse, ackedVersion string) {
+	s.t.Helper()
+
+	s.send(&discoverygrpcv3.DiscoveryRequest{
+		Node:          s.node,
+		TypeUrl:       s.typeURL,
+		VersionInfo:   ackedVersion,
+		ResponseNonce: res.GetNonce(),
+		ErrorDetail:   &status.Status{Message: "rejected by test"},
+	})
+}
+
+// expectNoResponse fails if a response is pushed within testNoRecvTimeout
+func (s *testSotwStream) expectNoResponse() {
+	s.t.Helper()
+
+	select {
+	case res, ok := <-s.responses:
+		if ok {
+			s.t.Fatalf("unexpected %s response version %s", typeName(s.typeURL), res.GetVersionInfo())
+		}
+	case <-time.After(testNoRecvTimeout):
+	}
+}
+
+// testResources decodes the resources of res
+func testResources(t *testing.T, res *discoverygrpcv3.DiscoveryResponse) map[string]proto.Message {
+	t.Helper()
+
+	resources := make(map[string]proto.Message, len(res.GetResources()))
+	for _, r := range res.GetResources() {
+		msg, err := r.UnmarshalNew()
+		if err != nil {
+			t.Fatalf("unmarshal %s: %s", r.GetTypeUrl(), err.Error())
+		}
+		resources[cachev3.GetResourceName(msg)] = msg
+	}
+	return resources
+}
+
+func testResourceNames(t *testing.T, res *discoverygrpcv3.DiscoveryResponse) []string {
+	t.Helper()
+
+	names := make([]string, 0, len(res.GetResources()))
+	for name := range testResources(t, res) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func testNode(id, cluster string) *corev3.Node {
+	return &corev3.Node{Id: id, Cluster: cluster}
+}
+
+// testFreeAddr reserves an ephemeral port, it is released for the server to listen
+func testFreeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err.Error())
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func testWaitListen(t *testing.T, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(testStartupTimeout)
+	for time.Now().Before(deadline) {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("%s is not listening", addr)
+}
+
+// syncBuffer is a bytes.Buffer safe for the access log flush and the test reads
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.buf.String()
+}
@@ -0,0 +1,42 @@
+package xds
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	resourceVersionLength int = 16
+)
+
+// resourceVersion returns the content hash of resources,
+// identical resources have identical version on any instance and across restarts
+func resourceVersion(resources ...proto.Message) (string, error) {
+	opt := proto.MarshalOptions{Deterministic: true}
+	h := sha256.New()
+	size := make([]byte, binary.MaxVarintLen64)
+	for _, r := range resources {
+		data, err := opt.Marshal(r)
+		if err != nil {
+			return "", err
+		}
+		// length prefixed, [a, bc] and [ab, c] must differ
+		n := binary.PutUvarint(size, uint64(len(data)))
+		h.Write(size[:n])
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:resourceVersionLength], nil
+}
+
+// snapshotVersion returns the short version that identifies the combination of resource versions
+func snapshotVersion(versions ...string) string {
+	h := sha256.New()
+	for _, v := range versions {
+		h.Write([]byte(v))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:resourceVersionLength]
+}
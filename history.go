@@ -0,0 +1,188 @@
+package xds
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	defaultHistorySize int = 10
+
+	HistorySourceFiles        string = "files"
+	HistorySourceStateDir     string = "state-dir"
+	HistorySourceNackRollback string = "nack-rollback"
+	HistorySourceRollback     string = "rollback"
+	HistorySourcePin          string = "pin"
+)
+
+// HistoryEntry is an applied snapshot of a node group
+type HistoryEntry struct {
+	Id        uint64            `json:"id"`
+	AppliedAt time.Time         `json:"applied_at"`
+	Source    string            `json:"source"`
+	Version   string            `json:"version"`
+	Versions  ResourceVersions  `json:"versions"`
+	Checksums map[string]string `json:"checksums"` // file -> sha256
+	Changes   []string          `json:"changes"`   // from the previous entry
+}
+
+// NodeGroupHistory is the applied snapshots of a node group, newest first
+type NodeGroupHistory struct {
+	NodeGroup string          `json:"node_group"`
+	Pinned    uint64          `json:"pinned"` // 0 = tracking files
+	Current   string          `json:"current"`
+	Entries   []*HistoryEntry `json:"entries"`
+}
+
+type historyEntry struct {
+	entry  *HistoryEntry
+	state  *resourceState
+	config nodeGroupConfig
+}
+
+func newHistoryEntry(id uint64, source string, state *resourceState, config nodeGroupConfig, checksums map[string]string, prev *resourceState) *historyEntry {
+	return &historyEntry{
+		entry: &HistoryEntry{
+			Id:        id,
+			AppliedAt: time.Now(),
+			Source:    source,
+			Version:   state.version(),
+			Versions:  resourceVersions(state),
+			Checksums: checksums,
+			Changes:   summaryDiff(prev, state),
+		},
+		state:  state,
+		config: config,
+	}
+}
+
+func resourceVersions(s *resourceState) ResourceVersions {
+	return ResourceVersions{
+		Clusters:  s.clustersVersion,
+		Endpoints: s.endpointsVersion,
+		Routes:    s.routeVersion,
+		Listeners: s.listenerVersion,
+		Secrets:   s.secretsVersion,
+		Runtime:   s.runtimeVersion,
+	}
+}
+
+// fileChecksums returns the checksum of each file, conf.d directories are expanded to their files
+func fileChecksums(paths []string) map[string]string {
+	files := make([]string, 0, len(paths))
+	for _, path := range paths {
+		expanded, err := configFiles(path)
+		if err != nil {
+			continue
+		}
+		files = append(files, expanded...)
+	}
+
+	checksums := make(map[string]string, len(files))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		checksums[file] = hex.EncodeToString(sum[:])
+	}
+	return checksums
+}
+
+// summaryDiff returns the changed resource names as "<TYPE> +name", "<TYPE> -name" and "<TYPE> ~name"
+func summaryDiff(prev, next *resourceState) []string {
+	if prev == nil {
+		prev = new(resourceState)
+	}
+
+	changes := make([]string, 0)
+	changes = append(changes, diffNames("CDS", clusterMessages(prev), clusterMessages(next))...)
+	changes = append(changes, diffNames("EDS", endpointMessages(prev), endpointMessages(next))...)
+	changes = append(changes, diffNames("RDS", virtualHostMessages(prev), virtualHostMessages(next))...)
+	changes = append(changes, diffNames("LDS", listenerMessages(prev), listenerMessages(next))...)
+	changes = append(changes, diffNames("SDS", secretMessages(prev), secretMessages(next))...)
+	changes = append(changes, diffNames("RTDS", runtimeMessages(prev), runtimeMessages(next))...)
+	return changes
+}
+
+func diffNames(typ string, prev, next map[string]proto.Message) []string {
+	names := make([]string, 0, len(prev)+len(next))
+	for name := range prev {
+		names = append(names, name)
+	}
+	for name := range next {
+		if _, ok := prev[name]; ok != true {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := make([]string, 0)
+	for _, name := range names {
+		p, inPrev := prev[name]
+		n, inNext := next[name]
+		switch {
+		case inPrev != true:
+			changes = append(changes, typ+" +"+name)
+		case inNext != true:
+			changes = append(changes, typ+" -"+name)
+		case proto.Equal(p, n) != true:
+			changes = append(changes, typ+" ~"+name)
+		}
+	}
+	return changes
+}
+
+func clusterMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message, len(s.clusters))
+	for _, c := range s.clusters {
+		m[c.GetName()] = c
+	}
+	return m
+}
+
+func endpointMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message, len(s.endpoints))
+	for _, e := range s.endpoints {
+		m[e.GetClusterName()] = e
+	}
+	return m
+}
+
+func virtualHostMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message)
+	for _, vh := range s.route.GetVirtualHosts() {
+		m[vh.GetName()] = vh
+	}
+	return m
+}
+
+func listenerMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message, 1)
+	if s.listener != nil {
+		m[s.listener.GetName()] = s.listener
+	}
+	return m
+}
+
+func secretMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message, len(s.secrets))
+	for _, secret := range s.secrets {
+		m[secret.GetName()] = secret
+	}
+	return m
+}
+
+func runtimeMessages(s *resourceState) map[string]proto.Message {
+	m := make(map[string]proto.Message)
+	for key, value := range s.runtime.GetLayer().GetFields() {
+		m[key] = value
+	}
+	return m
+}
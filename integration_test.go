@@ -0,0 +1,628 @@
+package xds
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	accesslogdatav3 "github.com/envoyproxy/go-control-plane/envoy/data/accesslog/v3"
+	alsv3 "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestIntegrationInitialSnapshot(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	node := testNode("node-1", "example")
+	tests := []struct {
+		typeURL string
+		names   []string
+	}{
+		{resourcev3.ClusterType, []string{"example_xds_cluster_web_api_legacy", "example_xds_cluster_web_api_new", "example_xds_cluster_web_image"}},
+		{resourcev3.EndpointType, []string{"example_xds_eds_web_api_legacy", "example_xds_eds_web_api_new", "example_xds_eds_web_image"}},
+		{resourcev3.RouteType, []string{"example_xds_route_config"}},
+		{resourcev3.ListenerType, []string{"example_xds_listener"}},
+		{resourcev3.RuntimeType, []string{BootstrapRuntimeLayerName}},
+	}
+	for _, tt := range tests {
+		t.Run(typeName(tt.typeURL), func(tc *testing.T) {
+			res := p.subscribe(tt.typeURL, node).recvAck()
+			if names := testResourceNames(tc, res); reflect.DeepEqual(names, tt.names) != true {
+				tc.Errorf("resources: expect %v actual %v", tt.names, names)
+			}
+			if expect := p.snapshotVersion(DefaultNodeGroupName, tt.typeURL); res.GetVersionInfo() != expect {
+				tc.Errorf("version: expect %s actual %s", expect, res.GetVersionInfo())
+			}
+		})
+	}
+}
+
+func TestIntegrationFileChange(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	stream := p.subscribe(resourcev3.EndpointType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+
+	p.replaceFile(NodeGroupEdsFileName, "10.10.1.101", "10.10.1.201")
+
+	next := stream.recvAck()
+	if next.GetVersionInfo() == prev.GetVersionInfo() {
+		t.Errorf("version must be changed: %s", next.GetVersionInfo())
+	}
+	if expect := p.snapshotVersion(DefaultNodeGroupName, resourcev3.EndpointType); next.GetVersionInfo() != expect {
+		t.Errorf("version: expect %s actual %s", expect, next.GetVersionInfo())
+	}
+
+	cla := testResources(t, next)["example_xds_eds_web_api_legacy"].(*endpointv3.ClusterLoadAssignment)
+	addrs := make([]string, 0)
+	for _, locality := range cla.GetEndpoints() {
+		for _, lb := range locality.GetLbEndpoints() {
+			addrs = append(addrs, lb.GetEndpoint().GetAddress().GetSocketAddress().GetAddress())
+		}
+	}
+	expect := []string{"10.10.1.201", "10.10.1.102", "10.10.1.103"}
+	if reflect.DeepEqual(addrs, expect) != true {
+		t.Errorf("addresses: expect %v actual %v", expect, addrs)
+	}
+}
+
+func TestIntegrationBrokenFileKeepsSnapshot(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	stream := p.subscribe(resourcev3.ClusterType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+	valid := p.readFile(NodeGroupCdsFileName)
+
+	p.writeFile(NodeGroupCdsFileName, "- name: [broken\n")
+	stream.expectNoResponse()
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.ClusterType); v != prev.GetVersionInfo() {
+		t.Errorf("broken file must not be published: %s -> %s", prev.GetVersionInfo(), v)
+	}
+
+	p.writeFile(NodeGroupCdsFileName, strings.Replace(valid, "least-request", "random", 1))
+	next := stream.recvAck()
+	cluster := testResources(t, next)["example_xds_cluster_web_image"].(*clusterv3.Cluster)
+	if cluster.GetLbPolicy() != clusterv3.Cluster_RANDOM {
+		t.Errorf("lb policy: expect RANDOM actual %s", cluster.GetLbPolicy())
+	}
+}
+
+func TestIntegrationNodeGroup(t *testing.T) {
+	p := newTestControlPlane(t)
+	for _, name := range []string{NodeGroupCdsFileName, NodeGroupRdsFileName, NodeGroupLdsFileName} {
+		p.writeFile(filepath.Join("canary", name), p.readFile(name))
+	}
+	p.writeFile(filepath.Join("canary", NodeGroupEdsFileName), strings.Replace(p.readFile(NodeGroupEdsFileName), "port: 3001", "port: 4001", -1))
+	p.watchOpts = append(p.watchOpts, WatchNodeGroups(NodeGroup{
+		Name:    "canary",
+		Cluster: "canary-*",
+		Dir:     p.path("canary"),
+	}))
+	p.start()
+
+	port := func(name string, node *corev3.Node) uint32 {
+		res := p.subscribe(resourcev3.EndpointType, node).recvAck()
+		cla := testResources(t, res)[name].(*endpointv3.ClusterLoadAssignment)
+		return cla.GetEndpoints()[0].GetLbEndpoints()[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue()
+	}
+	if v := port("example_xds_eds_web_api_new", testNode("node-1", "example")); v != 3001 {
+		t.Errorf("default group port: expect 3001 actual %d", v)
+	}
+	if v := port("example_xds_eds_web_api_new", testNode("node-2", "canary-a")); v != 4001 {
+		t.Errorf("canary group port: expect 4001 actual %d", v)
+	}
+
+	prev := p.snapshotVersion("canary", resourcev3.EndpointType)
+	defaultPrev := p.snapshotVersion(DefaultNodeGroupName, resourcev3.EndpointType)
+	p.replaceFile(filepath.Join("canary", NodeGroupEdsFileName), "port: 4001", "port: 5001")
+	testEventually(t, func() bool {
+		return p.snapshotVersion("canary", resourcev3.EndpointType) != prev
+	})
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.EndpointType); v != defaultPrev {
+		t.Errorf("default group must not be changed: %s -> %s", defaultPrev, v)
+	}
+	if v := port("example_xds_eds_web_api_new", testNode("node-3", "canary-b")); v != 5001 {
+		t.Errorf("canary group port: expect 5001 actual %d", v)
+	}
+}
+
+func TestIntegrationNackRollback(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.serverOpts = append(p.serverOpts, RollbackOnNack(0.5))
+	p.start()
+
+	node := testNode("node-1", "example")
+	stream := p.subscribe(resourcev3.ClusterType, node)
+	good := stream.recvAck()
+
+	p.replaceFile(NodeGroupCdsFileName, "least-request", "random")
+	bad := stream.recv()
+	if bad.GetVersionInfo() == good.GetVersionInfo() {
+		t.Fatalf("version must be changed: %s", bad.GetVersionInfo())
+	}
+	stream.nack(bad, good.GetVersionInfo())
+
+	testEventually(t, func() bool {
+		return p.snapshotVersion(DefaultNodeGroupName, resourcev3.ClusterType) == good.GetVersionInfo()
+	})
+	res := p.subscribe(resourcev3.ClusterType, testNode("node-2", "example")).recvAck()
+	if res.GetVersionInfo() != good.GetVersionInfo() {
+		t.Errorf("new node must receive the last-known-good version: expect %s actual %s", good.GetVersionInfo(), res.GetVersionInfo())
+	}
+}
+
+func TestIntegrationNackPinned(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.serverOpts = append(p.serverOpts, RollbackOnNack(0.5))
+	p.start()
+
+	node := testNode("node-1", "example")
+	stream := p.subscribe(resourcev3.ClusterType, node)
+	good := stream.recvAck()
+
+	p.replaceFile(NodeGroupCdsFileName, "least-request", "random")
+	bad := stream.recv()
+
+	var pinned uint64
+	for _, h := range p.watch.History() {
+		for _, e := range h.Entries {
+			if e.Version == h.Current {
+				pinned = e.Id
+			}
+		}
+	}
+	if err := p.watch.PinHistory(DefaultNodeGroupName, pinned); err != nil {
+		t.Fatalf("pin: %s", err.Error())
+	}
+	stream.nack(bad, good.GetVersionInfo())
+	// the node is resent the pinned version instead of the last-known-good one
+	if res := stream.recv(); res.GetVersionInfo() != bad.GetVersionInfo() {
+		t.Errorf("pinned version must be resent: expect %s actual %s", bad.GetVersionInfo(), res.GetVersionInfo())
+	}
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.ClusterType); v != bad.GetVersionInfo() {
+		t.Errorf("pinned version must be kept: expect %s actual %s", bad.GetVersionInfo(), v)
+	}
+	for _, h := range p.watch.History() {
+		if h.Pinned != pinned {
+			t.Errorf("must be pinned to #%d actual #%d", pinned, h.Pinned)
+		}
+	}
+}
+
+func TestIntegrationDeltaADS(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	client, err := NewXdsClient(
+		XdsClientAddr(p.xdsAddr),
+		XdsClientNode("node-1", "example"),
+		XdsClientADS(true),
+		XdsClientDelta(true),
+	)
+	if err != nil {
+		t.Fatalf("client: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), testRecvTimeout)
+	defer cancel()
+
+	received := make(map[string][]string)
+	err = client.Run(ctx, func(res *XdsResponse) bool {
+		for _, r := range res.Resources {
+			received[res.Type] = append(received[res.Type], r.Name)
+		}
+		return len(received) < len(XdsClientTypes)
+	})
+	if err != nil {
+		t.Fatalf("run: %s", err.Error())
+	}
+	expect := map[string]int{"CDS": 3, "EDS": 3, "RDS": 1, "LDS": 1}
+	for typ, n := range expect {
+		if len(received[typ]) != n {
+			t.Errorf("%s: expect %d resources actual %v", typ, n, received[typ])
+		}
+	}
+}
+
+func TestIntegrationAccessLog(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	startTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	start, _ := ptypes.TimestampProto(startTime)
+	p.sendAccessLog(&alsv3.StreamAccessLogsMessage{
+		Identifier: &alsv3.StreamAccessLogsMessage_Identifier{
+			Node:    testNode("node-1", "example"),
+			LogName: "web",
+		},
+		LogEntries: &alsv3.StreamAccessLogsMessage_HttpLogs{
+			HttpLogs: &alsv3.StreamAccessLogsMessage_HTTPAccessLogEntries{
+				LogEntry: []*accesslogdatav3.HTTPAccessLogEntry{
+					&accesslogdatav3.HTTPAccessLogEntry{
+						CommonProperties: &accesslogdatav3.AccessLogCommon{
+							RouteName: "example_xds_route_/api/v1",
+							StartTime: start,
+							DownstreamRemoteAddress: &corev3.Address{
+								Address: &corev3.Address_SocketAddress{
+									SocketAddress: &corev3.SocketAddress{Address: "192.0.2.10"},
+								},
+							},
+						},
+						ProtocolVersion: accesslogdatav3.HTTPAccessLogEntry_HTTP11,
+						Request: &accesslogdatav3.HTTPRequestProperties{
+							RequestMethod: corev3.RequestMethod_GET,
+							Path:          "/api/v1/users",
+							UserAgent:     "curl/8.0",
+						},
+						Response: &accesslogdatav3.HTTPResponseProperties{
+							ResponseCode: &wrappers.UInt32Value{Value: 200},
+						},
+					},
+				},
+			},
+		},
+	})
+
+	var line string
+	testEventually(t, func() bool {
+		line = p.accessLog()
+		return line != ""
+	})
+	for _, field := range []string{
+		"id:web\t",
+		"time:" + startTime.In(defaultTZ).Format("2006-01-02 15:04:05.000") + "\t",
+		"route:example_xds_route_/api/v1\t",
+		"proto:HTTP11\t",
+		"method:GET\t",
+		"status:200\t",
+		"path:/api/v1/users\t",
+		"ua:curl/8.0\t",
+		"client:192.0.2.10\t",
+		"referer:-\t",
+	} {
+		if strings.Contains(line, field) != true {
+			t.Errorf("access log must contain '%s': %s", field, line)
+		}
+	}
+}
+
+func TestIntegrationAtomicRename(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	stream := p.subscribe(resourcev3.EndpointType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+
+	// editors and config management tools write a temp file and rename it over the original
+	content := strings.Replace(p.readFile(NodeGroupEdsFileName), "10.10.1.101", "10.10.1.201", 1)
+	p.writeFile(".eds.yaml.tmp", content)
+	if err := os.Rename(p.path(".eds.yaml.tmp"), p.path(NodeGroupEdsFileName)); err != nil {
+		t.Fatalf("rename: %s", err.Error())
+	}
+	next := stream.recvAck()
+	if next.GetVersionInfo() == prev.GetVersionInfo() {
+		t.Errorf("version must be changed: %s", next.GetVersionInfo())
+	}
+
+	// the renamed file keeps being watched
+	p.replaceFile(NodeGroupEdsFileName, "10.10.1.201", "10.10.1.202")
+	if last := stream.recvAck(); last.GetVersionInfo() == next.GetVersionInfo() {
+		t.Errorf("version must be changed after rename: %s", last.GetVersionInfo())
+	}
+}
+
+func TestIntegrationConfigMapSymlinkSwap(t *testing.T) {
+	p := newTestControlPlane(t)
+	// kubernetes ConfigMap volume: eds.yaml -> ..data/eds.yaml, ..data -> ..<timestamp>
+	p.writeFile(filepath.Join("cm", "..2026_01", NodeGroupEdsFileName), p.readFile(NodeGroupEdsFileName))
+	if err := os.Symlink("..2026_01", p.path(filepath.Join("cm", "..data"))); err != nil {
+		t.Fatalf("symlink: %s", err.Error())
+	}
+	if err := os.Symlink(filepath.Join("..data", NodeGroupEdsFileName), p.path(filepath.Join("cm", NodeGroupEdsFileName))); err != nil {
+		t.Fatalf("symlink: %s", err.Error())
+	}
+	p.watchOpts = append(p.watchOpts, WatchEdsConfigFile(p.path(filepath.Join("cm", NodeGroupEdsFileName))))
+	p.start()
+
+	stream := p.subscribe(resourcev3.EndpointType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+
+	swap := func(from, to string, content string) {
+		p.writeFile(filepath.Join("cm", to, NodeGroupEdsFileName), content)
+		if err := os.Symlink(to, p.path(filepath.Join("cm", "..data_tmp"))); err != nil {
+			t.Fatalf("symlink: %s", err.Error())
+		}
+		if err := os.Rename(p.path(filepath.Join("cm", "..data_tmp")), p.path(filepath.Join("cm", "..data"))); err != nil {
+			t.Fatalf("rename: %s", err.Error())
+		}
+		if err := os.RemoveAll(p.path(filepath.Join("cm", from))); err != nil {
+			t.Fatalf("remove: %s", err.Error())
+		}
+	}
+	content := strings.Replace(p.readFile(NodeGroupEdsFileName), "port: 3002", "port: 4002", -1)
+	swap("..2026_01", "..2026_02", content)
+	next := stream.recvAck()
+	if next.GetVersionInfo() == prev.GetVersionInfo() {
+		t.Fatalf("version must be changed: %s", next.GetVersionInfo())
+	}
+	cla := testResources(t, next)["example_xds_eds_web_image"].(*endpointv3.ClusterLoadAssignment)
+	if port := cla.GetEndpoints()[0].GetLbEndpoints()[0].GetEndpoint().GetAddress().GetSocketAddress().GetPortValue(); port != 4002 {
+		t.Errorf("port: expect 4002 actual %d", port)
+	}
+
+	// swapped again
+	swap("..2026_02", "..2026_03", strings.Replace(content, "port: 4002", "port: 5002", -1))
+	if last := stream.recvAck(); last.GetVersionInfo() == next.GetVersionInfo() {
+		t.Errorf("version must be changed on the second swap: %s", last.GetVersionInfo())
+	}
+}
+
+func TestIntegrationWatchPolling(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.watchOpts = append(p.watchOpts, WatchPolling(true), WatchPollInterval(50*time.Millisecond))
+	p.start()
+
+	if st := p.watch.WatchStatus(); st.Mode != WatchModePolling || st.Status != WatchStatusOk {
+		t.Errorf("status: expect %s/%s actual %s/%s", WatchModePolling, WatchStatusOk, st.Mode, st.Status)
+	}
+
+	stream := p.subscribe(resourcev3.EndpointType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+	p.replaceFile(NodeGroupEdsFileName, "10.10.1.101", "10.10.1.201")
+	if next := stream.recvAck(); next.GetVersionInfo() == prev.GetVersionInfo() {
+		t.Errorf("version must be changed: %s", next.GetVersionInfo())
+	}
+}
+
+func TestIntegrationWatchLost(t *testing.T) {
+	p := newTestControlPlane(t)
+	content := p.readFile(NodeGroupEdsFileName)
+	p.writeFile(filepath.Join("eds", NodeGroupEdsFileName), content)
+	p.watchOpts = append(p.watchOpts, WatchEdsConfigFile(p.path(filepath.Join("eds", NodeGroupEdsFileName))))
+	p.start()
+
+	if st := p.watch.WatchStatus(); st.Mode != WatchModeNotify || st.Status != WatchStatusOk {
+		t.Fatalf("status: expect %s/%s actual %s/%s", WatchModeNotify, WatchStatusOk, st.Mode, st.Status)
+	}
+	stream := p.subscribe(resourcev3.EndpointType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+
+	// the watched directory is gone
+	if err := os.RemoveAll(p.path("eds")); err != nil {
+		t.Fatalf("remove: %s", err.Error())
+	}
+	testEventually(t, func() bool {
+		return p.watch.WatchStatus().Status == WatchStatusLost
+	})
+	if st := p.watch.WatchStatus(); containsString(st.Missing, p.path("eds")) != true || st.LastError == "" {
+		t.Errorf("lost directory must be reported: %+v", st)
+	}
+	stream.expectNoResponse()
+
+	p.writeFile(filepath.Join("eds", NodeGroupEdsFileName), strings.Replace(content, "10.10.1.101", "10.10.1.201", 1))
+	next := stream.recvAck()
+	if next.GetVersionInfo() == prev.GetVersionInfo() {
+		t.Errorf("version must be changed: %s", next.GetVersionInfo())
+	}
+	testEventually(t, func() bool {
+		return p.watch.WatchStatus().Status == WatchStatusOk
+	})
+}
+
+// testEventually polls fn until it returns true or testRecvTimeout
+func testEventually(t *testing.T, fn func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testRecvTimeout)
+	for time.Now().Before(deadline) {
+		if fn() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("condition not satisfied within %s", testRecvTimeout)
+}
+
+func TestIntegrationConfDir(t *testing.T) {
+	p := newTestControlPlane(t)
+	split := func(name string, dir string, sep string) {
+		content := p.readFile(name)
+		i := strings.Index(content, sep)
+		if i < 0 {
+			t.Fatalf("%s does not contain '%s'", name, sep)
+		}
+		p.writeFile(filepath.Join(dir, "api.yaml"), content[:i])
+		p.writeFile(filepath.Join(dir, "image.yaml"), content[i:])
+	}
+	split(NodeGroupCdsFileName, "cds.d", "- name: web-image")
+	split(NodeGroupEdsFileName, "eds.d", "- name: web-image")
+	split(NodeGroupRdsFileName, "rds.d", "- vhost: \"vhost-image\"")
+	p.watchOpts = append(p.watchOpts,
+		WatchCdsConfigFile(p.path("cds.d")),
+		WatchEdsConfigFile(p.path("eds.d")),
+		WatchRdsConfigFile(p.path("rds.d")),
+	)
+	p.start()
+
+	stream := p.subscribe(resourcev3.ClusterType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+	expect := []string{"example_xds_cluster_web_api_legacy", "example_xds_cluster_web_api_new", "example_xds_cluster_web_image"}
+	if names := testResourceNames(t, prev); reflect.DeepEqual(names, expect) != true {
+		t.Errorf("merged resources: expect %v actual %v", expect, names)
+	}
+
+	// another team defines the same cluster
+	p.writeFile(filepath.Join("cds.d", "zz-dup.yaml"), p.readFile(filepath.Join("cds.d", "image.yaml")))
+	stream.expectNoResponse()
+	lastError := ""
+	for _, st := range p.watch.ConfigStates() {
+		for _, f := range st.Files {
+			if st.NodeGroup == DefaultNodeGroupName && f.Type == "CDS" {
+				lastError = f.LastError
+			}
+		}
+	}
+	if strings.Contains(lastError, "zz-dup.yaml") != true {
+		t.Errorf("error must name the offending file: %s", lastError)
+	}
+	p.removeFile(filepath.Join("cds.d", "zz-dup.yaml"))
+
+	// files removed at runtime, the route to the cluster is removed together
+	p.removeFile(filepath.Join("rds.d", "image.yaml"))
+	p.removeFile(filepath.Join("eds.d", "image.yaml"))
+	p.removeFile(filepath.Join("cds.d", "image.yaml"))
+	next := stream.recvAck()
+	expect = []string{"example_xds_cluster_web_api_legacy", "example_xds_cluster_web_api_new"}
+	if names := testResourceNames(t, next); reflect.DeepEqual(names, expect) != true {
+		t.Errorf("resources after remove: expect %v actual %v", expect, names)
+	}
+}
+
+func TestIntegrationMultiFileTransaction(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	node := testNode("node-1", "example")
+	cds := p.subscribe(resourcev3.ClusterType, node)
+	eds := p.subscribe(resourcev3.EndpointType, node)
+	prevCds, prevEds := cds.recvAck(), eds.recvAck()
+	historySize := func() int {
+		for _, h := range p.watch.History() {
+			if h.NodeGroup == DefaultNodeGroupName {
+				return len(h.Entries)
+			}
+		}
+		return 0
+	}
+	applied := historySize()
+
+	newCluster := "- name: web-extra\n  lb-policy: \"round-robin\"\n  health-check: {path: /ready, status: [200], timeout: 3, interval: 3, healthy: 3, unhealthy: 3}\n"
+	newEndpoints := "- name: web-extra\n  balancing-policy: \"locality\"\n  instances:\n    - {instance-name: i-1, ip: 10.10.9.101, port: 3009, region: r, zone: z, protocol: tcp}\n"
+
+	// a cluster without endpoints is an inconsistent candidate, it must not be published
+	p.writeFile(NodeGroupCdsFileName, p.readFile(NodeGroupCdsFileName)+newCluster)
+	cds.expectNoResponse()
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.ClusterType); v != prevCds.GetVersionInfo() {
+		t.Errorf("intermediate snapshot must not be published: %s -> %s", prevCds.GetVersionInfo(), v)
+	}
+	for _, st := range p.watch.ConfigStates() {
+		for _, f := range st.Files {
+			if st.NodeGroup == DefaultNodeGroupName && f.Type == "CDS" && f.Status != FileStatusError {
+				t.Errorf("CDS status must be error: %+v", f)
+			}
+		}
+	}
+
+	// the second file completes the change, both are published in a single snapshot
+	p.writeFile(NodeGroupEdsFileName, p.readFile(NodeGroupEdsFileName)+newEndpoints)
+	nextCds, nextEds := cds.recvAck(), eds.recvAck()
+	if _, ok := testResources(t, nextCds)["example_xds_cluster_web_extra"]; ok != true {
+		t.Errorf("new cluster must be published: %v", testResourceNames(t, nextCds))
+	}
+	if _, ok := testResources(t, nextEds)["example_xds_eds_web_extra"]; ok != true {
+		t.Errorf("new endpoints must be published: %v", testResourceNames(t, nextEds))
+	}
+	if nextEds.GetVersionInfo() == prevEds.GetVersionInfo() {
+		t.Errorf("EDS version must be changed: %s", nextEds.GetVersionInfo())
+	}
+	if n := historySize(); n != applied+1 {
+		t.Errorf("history: expect %d snapshot(s) applied actual %d", 1, n-applied)
+	}
+}
+
+func TestIntegrationReloadAllOrNothing(t *testing.T) {
+	p := newTestControlPlane(t)
+	for _, name := range []string{NodeGroupCdsFileName, NodeGroupEdsFileName, NodeGroupRdsFileName, NodeGroupLdsFileName} {
+		p.writeFile(filepath.Join("canary", name), p.readFile(name))
+	}
+	// without file watching, changes are applied by ReloadAll(SIGHUP) only
+	p.watch = NewWatchFile(context.Background(),
+		WatchCdsConfigFile(p.path(NodeGroupCdsFileName)),
+		WatchEdsConfigFile(p.path(NodeGroupEdsFileName)),
+		WatchRdsConfigFile(p.path(NodeGroupRdsFileName)),
+		WatchLdsConfigFile(p.path(NodeGroupLdsFileName)),
+		WatchNodeGroups(NodeGroup{Name: "canary", Cluster: "canary-*", Dir: p.path("canary")}),
+	)
+	if err := p.watch.InitialLoad(); err != nil {
+		t.Fatalf("initial load: %s", err.Error())
+	}
+
+	prevDefault := p.snapshotVersion(DefaultNodeGroupName, resourcev3.EndpointType)
+	prevCanary := p.snapshotVersion("canary", resourcev3.ClusterType)
+	prevRoute := p.snapshotVersion("canary", resourcev3.RouteType)
+
+	p.replaceFile(NodeGroupEdsFileName, "10.10.1.101", "10.10.1.201")
+	p.replaceFile(filepath.Join("canary", NodeGroupCdsFileName), "least-request", "random")
+	p.writeFile(filepath.Join("canary", NodeGroupRdsFileName), "- vhost: [broken\n")
+
+	if err := p.watch.ReloadAll(); err == nil {
+		t.Fatalf("broken canary group must fail ReloadAll")
+	}
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.EndpointType); v != prevDefault {
+		t.Errorf("default group must not be published when another group is broken: %s -> %s", prevDefault, v)
+	}
+	if v := p.snapshotVersion("canary", resourcev3.ClusterType); v != prevCanary {
+		t.Errorf("canary CDS must not be half applied: %s -> %s", prevCanary, v)
+	}
+	canary, _ := p.watch.findGroup("canary")
+	if v, _ := canary.resource.currentCluster(); v != prevCanary {
+		t.Errorf("canary resource must not be mutated: %s -> %s", prevCanary, v)
+	}
+
+	p.writeFile(filepath.Join("canary", NodeGroupRdsFileName), p.readFile(NodeGroupRdsFileName))
+	if err := p.watch.ReloadAll(); err != nil {
+		t.Fatalf("reload: %s", err.Error())
+	}
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.EndpointType); v == prevDefault {
+		t.Errorf("default group must be published: %s", v)
+	}
+	if v := p.snapshotVersion("canary", resourcev3.ClusterType); v == prevCanary {
+		t.Errorf("canary CDS must be published: %s", v)
+	}
+	if v := p.snapshotVersion("canary", resourcev3.RouteType); v != prevRoute {
+		t.Errorf("canary RDS is unchanged: %s -> %s", prevRoute, v)
+	}
+}
+
+func TestIntegrationDanglingReferenceRejected(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	stream := p.subscribe(resourcev3.RouteType, testNode("node-1", "example"))
+	prev := stream.recvAck()
+
+	p.replaceFile(NodeGroupRdsFileName, "name: web-image", "name: web-video")
+	stream.expectNoResponse()
+	if v := p.snapshotVersion(DefaultNodeGroupName, resourcev3.RouteType); v != prev.GetVersionInfo() {
+		t.Errorf("route to an undefined cluster must not be published: %s -> %s", prev.GetVersionInfo(), v)
+	}
+	for _, st := range p.watch.ConfigStates() {
+		for _, f := range st.Files {
+			if st.NodeGroup == DefaultNodeGroupName && f.Type == "RDS" {
+				if strings.Contains(f.LastError, "[1].cluster[0].target[0].name: cluster 'web-video' of vhost 'vhost-image'") != true {
+					t.Errorf("RDS error must report the dangling reference: %s", f.LastError)
+				}
+			}
+		}
+	}
+
+	// defining the cluster(and its endpoints) in the same change makes it consistent
+	p.replaceFile(NodeGroupCdsFileName, "- name: web-image", "- name: web-video")
+	p.replaceFile(NodeGroupEdsFileName, "- name: web-image", "- name: web-video")
+	if next := stream.recvAck(); next.GetVersionInfo() == prev.GetVersionInfo() {
+		t.Errorf("version must be changed: %s", next.GetVersionInfo())
+	}
+}
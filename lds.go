@@ -1,9 +1,7 @@
 package xds
 
 import (
-	"strconv"
 	"strings"
-	"sync/atomic"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
@@ -40,11 +38,6 @@ func initLdsOpt(opt *ldsOpt) {
 type listenerDiscoveryService struct {
 	opt       *ldsOpt
 	xdsConfig *corev3.ConfigSource
-	version   uint64
-}
-
-func (s *listenerDiscoveryService) increVersion() uint64 {
-	return atomic.AddUint64(&s.version, 1)
 }
 
 func (s *listenerDiscoveryService) httpConnectionManager(config LDSConfig) (*httpconnmgrv3.HttpConnectionManager, error) {
@@ -171,8 +164,12 @@ func (s *listenerDiscoveryService) create(config LDSConfig) (string, *listenerv3
 	}
 
 	filters := s.listenerFilters(managerConfig)
-	version := strconv.FormatUint(s.increVersion(), 10)
-	return version, s.listener(filters, config.Listen), nil
+	listener := s.listener(filters, config.Listen)
+	version, err := resourceVersion(listener)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, listener, nil
 }
 
 func newListenerDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...ldsOptFunc) *listenerDiscoveryService {
@@ -185,6 +182,5 @@ func newListenerDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...ldsOpt
 	return &listenerDiscoveryService{
 		opt:       opt,
 		xdsConfig: xdsConfig,
-		version:   uint64(0),
 	}
 }
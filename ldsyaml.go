@@ -7,16 +7,16 @@ import (
 )
 
 type LDSConfig struct {
-	Listen    LDSListenConfig    `yaml:"listen"           validate:"required"`
-	Server    LDSServerConfig    `yaml:"server"           validate:"required"`
-	Timeout   LDSTimeoutConfig   `yaml:"timeout"          validate:"required"`
-	AccessLog LDSAccessLogConfig `yaml:"accesslog"        validate:"required"`
+	Listen    LDSListenConfig    `yaml:"listen"           json:"listen"           validate:"required"`
+	Server    LDSServerConfig    `yaml:"server"           json:"server"           validate:"required"`
+	Timeout   LDSTimeoutConfig   `yaml:"timeout"          json:"timeout"          validate:"required"`
+	AccessLog LDSAccessLogConfig `yaml:"accesslog"        json:"accesslog"        validate:"required"`
 }
 
 type LDSListenConfig struct {
-	Protocol string `yaml:"protocol"         validate:"required"`
-	IP       string `yaml:"ip"               validate:"required,ip"`
-	Port     uint32 `yaml:"port"             validate:"required,gte=1,lte=65535"`
+	Protocol string `yaml:"protocol"         json:"protocol"         validate:"required"`
+	IP       string `yaml:"ip"               json:"ip"               validate:"required,ip"`
+	Port     uint32 `yaml:"port"             json:"port"             validate:"required,gte=1,lte=65535"`
 }
 
 func (c LDSListenConfig) Address() *corev3.Address {
@@ -45,17 +45,17 @@ func (c LDSListenConfig) addr(protocol corev3.SocketAddress_Protocol) *corev3.Ad
 }
 
 type LDSServerConfig struct {
-	ServerName     string `yaml:"name"             validate:"required,ascii"`
-	UseRemoteAddr  bool   `yaml:"use-remote-addr"  validate:"required"`
-	SkipXffAppend  bool   `yaml:"skip-xff-append"  validate:"required"`
-	XffTrustedHops uint32 `yaml:"xff-trusted-hops" validate"required"`
+	ServerName     string `yaml:"name"             json:"name"             validate:"required,ascii"`
+	UseRemoteAddr  bool   `yaml:"use-remote-addr"  json:"use-remote-addr"  validate:"required"`
+	SkipXffAppend  bool   `yaml:"skip-xff-append"  json:"skip-xff-append"  validate:"required"`
+	XffTrustedHops uint32 `yaml:"xff-trusted-hops" json:"xff-trusted-hops"`
 }
 
 type LDSTimeoutConfig struct {
-	RequestTimeout uint32 `yaml:"request-timeout"  validate:"required,gte=1"`
-	DrainTimeout   uint32 `yaml:"drain-timeout"    validate:"required,gte=1"`
-	IdleTimeout    uint32 `yaml:"idle-timeout"     validate:"required,gte=1"`
-	MaxDuration    uint32 `yaml:"max-duration"     validate:"required,gte=1"`
+	RequestTimeout uint32 `yaml:"request-timeout"  json:"request-timeout"  validate:"required,gte=1"`
+	DrainTimeout   uint32 `yaml:"drain-timeout"    json:"drain-timeout"    validate:"required,gte=1"`
+	IdleTimeout    uint32 `yaml:"idle-timeout"     json:"idle-timeout"     validate:"required,gte=1"`
+	MaxDuration    uint32 `yaml:"max-duration"     json:"max-duration"     validate:"required,gte=1"`
 }
 
 func (c LDSTimeoutConfig) RequestTimeoutSecond() time.Duration {
@@ -75,9 +75,9 @@ func (c LDSTimeoutConfig) MaxDurationSecond() time.Duration {
 }
 
 type LDSAccessLogConfig struct {
-	LogId         string `yaml:"log-id"          validate:"required"`
-	FlushInterval uint32 `yaml:"flush-interval"  validate:"required,gte=1"`
-	BufferSize    uint32 `yaml:"buffer-size"     validate:"required,gte=1"`
+	LogId         string `yaml:"log-id"          json:"log-id"          validate:"required"`
+	FlushInterval uint32 `yaml:"flush-interval"  json:"flush-interval"  validate:"required,gte=1"`
+	BufferSize    uint32 `yaml:"buffer-size"     json:"buffer-size"     validate:"required,gte=1"`
 }
 
 func (c LDSAccessLogConfig) FlushIntervalSecond() time.Duration {
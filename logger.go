@@ -1,8 +1,12 @@
 package xds
 
 import (
+	"bufio"
+	"context"
+	"io"
 	"log"
-	"os"
+	"sync"
+	"time"
 
 	envoylog "github.com/envoyproxy/go-control-plane/pkg/log"
 )
@@ -34,7 +38,49 @@ func newLoggerSnapshotCache() *loggerSnapshotCache {
 	return new(loggerSnapshotCache)
 }
 
-func newLoggerAccessLog() *log.Logger {
-	// todo
-	return log.New(os.Stdout, "accesslog: ", log.Ldate|log.Lmicroseconds)
+// accessLogWriter buffers access log writes, flushed periodically and on shutdown
+type accessLogWriter struct {
+	mutex *sync.Mutex
+	buf   *bufio.Writer
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buf.Write(p)
+}
+
+func (w *accessLogWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.buf.Flush()
+}
+
+func (w *accessLogWriter) flushLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				log.Printf("warn: access log flush error: %s", err.Error())
+			}
+		}
+	}
+}
+
+func newAccessLogWriter(out io.Writer, size int) *accessLogWriter {
+	return &accessLogWriter{
+		mutex: new(sync.Mutex),
+		buf:   bufio.NewWriterSize(out, size),
+	}
+}
+
+func newLoggerAccessLog(out io.Writer) *log.Logger {
+	return log.New(out, "accesslog: ", log.Ldate|log.Lmicroseconds)
 }
@@ -0,0 +1,151 @@
+package xds
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace string = "example_envoy_xds"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricReloadTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reload_total",
+		Help:      "Number of reload attempts by the type whose config file is changed.",
+	}, []string{"node_group", "type"})
+
+	metricReloadFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reload_failures_total",
+		Help:      "Number of failed reload attempts by the type whose config file is changed.",
+	}, []string{"node_group", "type"})
+
+	metricConfigFileError = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_file_error",
+		Help:      "1 if the last reload of the config file failed, the previous resources are served.",
+	}, []string{"node_group", "type"})
+
+	metricFileWatchLost = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "file_watch_lost",
+		Help:      "1 while config files can not be watched by fsnotify and changes are found by polling.",
+	})
+
+	metricSnapshotInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "snapshot_info",
+		Help:      "Version of the snapshot currently set to the cache.",
+	}, []string{"node_group", "version"})
+
+	metricSnapshotTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "snapshot_last_update_timestamp_seconds",
+		Help:      "Unix time the snapshot was last set to the cache.",
+	}, []string{"node_group"})
+
+	metricSnapshotPushSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "snapshot_push_duration_seconds",
+		Help:      "Time to build and set a snapshot to the cache, ADS warming stages are published later.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"node_group"})
+
+	metricResponseAckSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "response_ack_duration_seconds",
+		Help:      "Time from a discovery response sent to envoy until it is ACKed or NACKed.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"type", "result"})
+
+	metricOpenStreams = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "open_streams",
+		Help:      "Number of open xDS streams.",
+	}, []string{"type", "delta"})
+
+	metricAlsMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "als_messages_total",
+		Help:      "Number of access log stream messages received.",
+	}, []string{"log_id"})
+
+	metricAlsEntriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "als_entries_total",
+		Help:      "Number of access log entries received.",
+	}, []string{"log_id"})
+
+	metricAlsDroppedEntriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "als_dropped_entries_total",
+		Help:      "Number of access log entries dropped by the access log writer.",
+	}, []string{"log_id"})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		metricReloadTotal,
+		metricReloadFailuresTotal,
+		metricConfigFileError,
+		metricFileWatchLost,
+		metricSnapshotInfo,
+		metricSnapshotTimestamp,
+		metricSnapshotPushSeconds,
+		metricResponseAckSeconds,
+		metricOpenStreams,
+		metricAlsMessagesTotal,
+		metricAlsEntriesTotal,
+		metricAlsDroppedEntriesTotal,
+	)
+}
+
+func metricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+func observeReload(group string, typ string, err error) {
+	metricReloadTotal.WithLabelValues(group, typ).Inc()
+	failures := metricReloadFailuresTotal.WithLabelValues(group, typ) // export zero before the first failure
+	if err != nil {
+		failures.Inc()
+	}
+}
+
+func observeFileError(group string, typ string, err error) {
+	if err != nil {
+		metricConfigFileError.WithLabelValues(group, typ).Set(1)
+		return
+	}
+	metricConfigFileError.WithLabelValues(group, typ).Set(0)
+}
+
+func observeWatchLost(lost bool) {
+	if lost {
+		metricFileWatchLost.Set(1)
+		return
+	}
+	metricFileWatchLost.Set(0)
+}
+
+func observeSnapshot(group string, version string) {
+	metricSnapshotInfo.DeletePartialMatch(prometheus.Labels{"node_group": group})
+	metricSnapshotInfo.WithLabelValues(group, version).Set(1)
+	metricSnapshotTimestamp.WithLabelValues(group).SetToCurrentTime()
+}
+
+func streamTypeLabel(typeURL string) string {
+	if typeURL == "" {
+		return "ADS"
+	}
+	return typeName(typeURL)
+}
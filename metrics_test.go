@@ -0,0 +1,74 @@
+package xds
+
+import (
+	"testing"
+
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+// testCounterValue is the value of the counter name with labels, 0 if not exported yet
+func testCounterValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := metricsRegistry.Gather()
+	if err != nil {
+		t.Fatalf("gather: %s", err.Error())
+	}
+	for _, f := range families {
+		if f.GetName() != metricsNamespace+"_"+name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			matched := 0
+			for _, l := range m.GetLabel() {
+				if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+					matched += 1
+				}
+			}
+			if matched == len(labels) {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestReloadMetricsByChangedType(t *testing.T) {
+	p := newTestControlPlane(t)
+	p.start()
+
+	types := []string{"CDS", "EDS", "RDS", "LDS", "RTDS"}
+	count := func(name string) map[string]float64 {
+		values := make(map[string]float64, len(types))
+		for _, typ := range types {
+			values[typ] = testCounterValue(t, name, map[string]string{"node_group": DefaultNodeGroupName, "type": typ})
+		}
+		return values
+	}
+	testDelta := func(name string, prev map[string]float64, expect map[string]float64) {
+		t.Helper()
+		actual := count(name)
+		for _, typ := range types {
+			if d := actual[typ] - prev[typ]; d != expect[typ] {
+				t.Errorf("%s{type=%s}: expect +%v actual +%v", name, typ, expect[typ], d)
+			}
+		}
+	}
+
+	stream := p.subscribe(resourcev3.EndpointType, testNode("node-1", "example"))
+	stream.recvAck()
+
+	total, failures := count("reload_total"), count("reload_failures_total")
+	p.replaceFile(NodeGroupEdsFileName, "10.10.1.101", "10.10.1.201")
+	stream.recvAck()
+	testDelta("reload_total", total, map[string]float64{"EDS": 1})
+	testDelta("reload_failures_total", failures, map[string]float64{})
+
+	total, failures = count("reload_total"), count("reload_failures_total")
+	p.writeFile(NodeGroupRdsFileName, "- vhost: [broken\n")
+	testEventually(t, func() bool {
+		return count("reload_total")["RDS"] == total["RDS"]+1
+	})
+	testDelta("reload_total", total, map[string]float64{"RDS": 1})
+	testDelta("reload_failures_total", failures, map[string]float64{"RDS": 1})
+}
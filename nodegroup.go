@@ -0,0 +1,319 @@
+package xds
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+)
+
+const (
+	DefaultNodeGroupName     string = "default"
+	NodeGroupCdsFileName     string = "cds.yaml"
+	NodeGroupEdsFileName     string = "eds.yaml"
+	NodeGroupRdsFileName     string = "rds.yaml"
+	NodeGroupLdsFileName     string = "lds.yaml"
+	NodeGroupSdsFileName     string = "sds.yaml"
+	NodeGroupRuntimeFileName string = "runtime.yaml"
+)
+
+// compile check
+var (
+	_ cachev3.NodeHash = (*nodeGroupHash)(nil)
+)
+
+// NodeGroup is a set of envoy nodes that share the same snapshot.
+// Dir contains cds.yaml, eds.yaml, rds.yaml, lds.yaml and optional sds.yaml, runtime.yaml,
+// each of them can be a conf.d directory instead(cds.d, eds.d ...) whose yaml files are merged.
+// Cluster and NodeId are glob patterns (path.Match), if both are specified both must match.
+// a malformed pattern never matches, check it with path.Match(pattern, "") beforehand.
+type NodeGroup struct {
+	Name    string
+	Cluster string
+	NodeId  string
+	Dir     string
+}
+
+func (g NodeGroup) match(node *corev3.Node) bool {
+	if g.Cluster == "" && g.NodeId == "" {
+		return false
+	}
+	if g.Cluster != "" {
+		if ok, _ := path.Match(g.Cluster, node.GetCluster()); ok != true {
+			return false
+		}
+	}
+	if g.NodeId != "" {
+		if ok, _ := path.Match(g.NodeId, node.GetId()); ok != true {
+			return false
+		}
+	}
+	return true
+}
+
+type nodeGroupHash struct {
+	groups       []NodeGroup
+	defaultGroup string
+}
+
+// ID returns the snapshot key of node, first matched group wins
+func (h *nodeGroupHash) ID(node *corev3.Node) string {
+	if node == nil {
+		return h.defaultGroup
+	}
+	for _, g := range h.groups {
+		if g.match(node) {
+			return g.Name
+		}
+	}
+	return h.defaultGroup
+}
+
+func newNodeGroupHash(groups []NodeGroup, defaultGroup string) *nodeGroupHash {
+	return &nodeGroupHash{
+		groups:       groups,
+		defaultGroup: defaultGroup,
+	}
+}
+
+type nodeGroup struct {
+	name        string
+	cdsYaml     string
+	edsYaml     string
+	rdsYaml     string
+	ldsYaml     string
+	sdsYaml     string
+	runtimeYaml string
+	secretFiles []string
+	resource    *resource
+	published   *resourceState
+	lastGood    *resourceState // rollback target on NACK
+	lastGoodCfg nodeGroupConfig
+	config      *nodeGroupConfig
+	publishCfg  nodeGroupConfig
+	pinned      uint64                 // history id, 0 = tracking files
+	fileStatus  map[string]*FileStatus // xDS type -> last reload result
+	adsTimer    *time.Timer            // next ADS warming stage
+	adsSeq      uint64
+}
+
+func (g *nodeGroup) files() []string {
+	files := []string{
+		g.cdsYaml,
+		g.edsYaml,
+		g.rdsYaml,
+		g.ldsYaml,
+	}
+	if g.sdsYaml != "" {
+		files = append(files, g.sdsYaml)
+		files = append(files, g.secretFiles...)
+	}
+	if g.runtimeYaml != "" {
+		files = append(files, g.runtimeYaml)
+	}
+	return files
+}
+
+func (g *nodeGroup) isSecretFile(name string) bool {
+	for _, file := range g.secretFiles {
+		if equalPath(name, file) {
+			return true
+		}
+	}
+	return false
+}
+
+type nodeGroupFile struct {
+	typ  string
+	file string
+}
+
+// typeFiles returns the config file(or conf.d directory) of each xDS type served by g
+func (g *nodeGroup) typeFiles() []nodeGroupFile {
+	files := []nodeGroupFile{
+		{"CDS", g.cdsYaml},
+		{"EDS", g.edsYaml},
+		{"RDS", g.rdsYaml},
+		{"LDS", g.ldsYaml},
+	}
+	if g.sdsYaml != "" {
+		files = append(files, nodeGroupFile{"SDS", g.sdsYaml})
+	}
+	if g.runtimeYaml != "" {
+		files = append(files, nodeGroupFile{"RTDS", g.runtimeYaml})
+	}
+	return files
+}
+
+// changedTypes returns the xDS types whose files are in names
+func (g *nodeGroup) changedTypes(names []string) []string {
+	types := make([]string, 0)
+	for _, f := range g.typeFiles() {
+		for _, name := range names {
+			if matchConfigPath(name, f.file) || (f.typ == "SDS" && g.isSecretFile(name)) {
+				types = append(types, f.typ)
+				break
+			}
+		}
+	}
+	return types
+}
+
+// publish records state as published, the previous one becomes last-known-good
+func (g *nodeGroup) publish(state *resourceState) {
+	if g.published != nil && g.published.version() == state.version() {
+		return
+	}
+	g.lastGood = g.published
+	g.lastGoodCfg = g.publishCfg
+	g.published = state
+	g.publishCfg = *g.config
+}
+
+// cancelAdsStages stops the pending ADS warming stages
+func (g *nodeGroup) cancelAdsStages() {
+	g.adsSeq += 1
+	if g.adsTimer != nil {
+		g.adsTimer.Stop()
+		g.adsTimer = nil
+	}
+}
+
+// types returns the xDS types served by g
+func (g *nodeGroup) types() []string {
+	files := g.typeFiles()
+	types := make([]string, len(files))
+	for i, f := range files {
+		types[i] = f.typ
+	}
+	return types
+}
+
+// observeReload counts a reload attempt of types, the types whose files are changed
+func (g *nodeGroup) observeReload(types []string, err error) {
+	for _, typ := range types {
+		observeReload(g.name, typ, err)
+	}
+}
+
+func (g *nodeGroup) setFileStatus(typ string, file string, err error) {
+	observeFileError(g.name, typ, err)
+
+	st, ok := g.fileStatus[typ]
+	if ok != true {
+		st = &FileStatus{Type: typ}
+		g.fileStatus[typ] = st
+	}
+	st.File = file
+	if err != nil {
+		st.Status = FileStatusError
+		st.LastError = err.Error()
+		st.ErrorAt = time.Now()
+		return
+	}
+	st.Status = FileStatusOk
+	st.LoadedAt = time.Now()
+}
+
+func (g *nodeGroup) setAllFileStatus(err error) {
+	for _, f := range g.typeFiles() {
+		g.setFileStatus(f.typ, f.file, err)
+	}
+}
+
+// setErrorStatus records errs of a rejected candidate to the files they belong to,
+// errors of the whole snapshot(consistency) are recorded to all files
+func (g *nodeGroup) setErrorStatus(errs ValidationErrors) {
+	common := make([]string, 0)
+	byFile := make(map[string][]string)
+	for _, e := range errs {
+		matched := false
+		for _, f := range g.typeFiles() {
+			if matchConfigPath(e.File, f.file) {
+				msg := e.Message
+				if equalPath(e.File, f.file) != true {
+					msg = e.Error() // a file in conf.d
+				} else if e.Path != "" {
+					msg = e.Path + ": " + e.Message
+				}
+				byFile[f.typ] = append(byFile[f.typ], msg)
+				matched = true
+				break
+			}
+		}
+		if matched != true {
+			common = append(common, e.Message)
+		}
+	}
+	for _, f := range g.typeFiles() {
+		msgs := append(byFile[f.typ], common...)
+		if len(msgs) < 1 {
+			continue
+		}
+		g.setFileStatus(f.typ, f.file, fmt.Errorf("%s", strings.Join(msgs, "; ")))
+	}
+}
+
+func (g *nodeGroup) configState() *ConfigState {
+	state := &ConfigState{
+		NodeGroup: g.name,
+		CDS:       g.config.cds,
+		EDS:       g.config.eds,
+		RDS:       g.config.rds,
+		LDS:       g.config.lds,
+		SDS:       g.config.sds,
+		Runtime:   nil,
+		Files:     make([]*FileStatus, 0, len(g.fileStatus)),
+	}
+	if g.config.runtime != nil {
+		state.Runtime = jsonValue(map[string]interface{}(g.config.runtime)).(map[string]interface{})
+	}
+	for _, typ := range []string{"CDS", "EDS", "RDS", "LDS", "SDS", "RTDS"} {
+		if st, ok := g.fileStatus[typ]; ok {
+			copied := *st
+			state.Files = append(state.Files, &copied)
+		}
+	}
+	return state
+}
+
+func newNodeGroup(name string, cdsYaml, edsYaml, rdsYaml, ldsYaml, sdsYaml, runtimeYaml string) *nodeGroup {
+	return &nodeGroup{
+		name:        name,
+		cdsYaml:     cdsYaml,
+		edsYaml:     edsYaml,
+		rdsYaml:     rdsYaml,
+		ldsYaml:     ldsYaml,
+		sdsYaml:     sdsYaml,
+		runtimeYaml: runtimeYaml,
+		secretFiles: nil,
+		resource:    newResource(),
+		published:   nil,
+		lastGood:    nil,
+		config:      new(nodeGroupConfig),
+		fileStatus:  make(map[string]*FileStatus),
+	}
+}
+
+func newNodeGroupFromDir(name string, dir string) *nodeGroup {
+	return newNodeGroup(
+		name,
+		nodeGroupConfigPath(dir, NodeGroupCdsFileName),
+		nodeGroupConfigPath(dir, NodeGroupEdsFileName),
+		nodeGroupConfigPath(dir, NodeGroupRdsFileName),
+		nodeGroupConfigPath(dir, NodeGroupLdsFileName),
+		optionalFile(nodeGroupConfigPath(dir, NodeGroupSdsFileName)),
+		optionalFile(nodeGroupConfigPath(dir, NodeGroupRuntimeFileName)),
+	)
+}
+
+func optionalFile(path string) string {
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
@@ -0,0 +1,251 @@
+package xds
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	runtimev3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+)
+
+const (
+	persistDirMode os.FileMode = 0700
+)
+
+// persistedSnapshot is the last applied snapshot of a node group saved in the state directory,
+// it contains secrets and must not be readable by others
+type persistedSnapshot struct {
+	NodeGroup string              `json:"node_group"`
+	SavedAt   time.Time           `json:"saved_at"`
+	Version   string              `json:"version"`
+	Resources *persistedResources `json:"resources"`
+	Config    *persistedConfig    `json:"config"`
+}
+
+type persistedResources struct {
+	Versions  ResourceVersions  `json:"versions"`
+	Clusters  []json.RawMessage `json:"clusters"`
+	Endpoints []json.RawMessage `json:"endpoints"`
+	Route     json.RawMessage   `json:"route,omitempty"`
+	Listener  json.RawMessage   `json:"listener,omitempty"`
+	Secrets   []json.RawMessage `json:"secrets"`
+	Runtime   json.RawMessage   `json:"runtime,omitempty"`
+}
+
+type persistedConfig struct {
+	CDS     []CDSConfig            `json:"cds"`
+	EDS     []EDSConfig            `json:"eds"`
+	RDS     []RDSConfig            `json:"rds"`
+	LDS     *LDSConfig             `json:"lds"`
+	SDS     []SDSConfig            `json:"sds"`
+	Runtime map[string]interface{} `json:"runtime"`
+}
+
+func (p *persistedResources) state() (*resourceState, error) {
+	s := &resourceState{
+		endpoints:        make([]*endpointv3.ClusterLoadAssignment, len(p.Endpoints)),
+		endpointsVersion: p.Versions.Endpoints,
+		clusters:         make([]*clusterv3.Cluster, len(p.Clusters)),
+		clustersVersion:  p.Versions.Clusters,
+		route:            nil,
+		routeVersion:     p.Versions.Routes,
+		listener:         nil,
+		listenerVersion:  p.Versions.Listeners,
+		secrets:          make([]*tlsv3.Secret, len(p.Secrets)),
+		secretsVersion:   p.Versions.Secrets,
+		runtime:          nil,
+		runtimeVersion:   p.Versions.Runtime,
+	}
+	for i, data := range p.Endpoints {
+		s.endpoints[i] = new(endpointv3.ClusterLoadAssignment)
+		if err := protojson.Unmarshal(data, s.endpoints[i]); err != nil {
+			return nil, err
+		}
+	}
+	for i, data := range p.Clusters {
+		s.clusters[i] = new(clusterv3.Cluster)
+		if err := protojson.Unmarshal(data, s.clusters[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.Route) > 0 {
+		s.route = new(routev3.RouteConfiguration)
+		if err := protojson.Unmarshal(p.Route, s.route); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.Listener) > 0 {
+		s.listener = new(listenerv3.Listener)
+		if err := protojson.Unmarshal(p.Listener, s.listener); err != nil {
+			return nil, err
+		}
+	}
+	for i, data := range p.Secrets {
+		s.secrets[i] = new(tlsv3.Secret)
+		if err := protojson.Unmarshal(data, s.secrets[i]); err != nil {
+			return nil, err
+		}
+	}
+	if len(p.Runtime) > 0 {
+		s.runtime = new(runtimev3.Runtime)
+		if err := protojson.Unmarshal(p.Runtime, s.runtime); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func newPersistedResources(s *resourceState) (*persistedResources, error) {
+	p := &persistedResources{
+		Versions: ResourceVersions{
+			Clusters:  s.clustersVersion,
+			Endpoints: s.endpointsVersion,
+			Routes:    s.routeVersion,
+			Listeners: s.listenerVersion,
+			Secrets:   s.secretsVersion,
+			Runtime:   s.runtimeVersion,
+		},
+		Clusters:  make([]json.RawMessage, len(s.clusters)),
+		Endpoints: make([]json.RawMessage, len(s.endpoints)),
+		Secrets:   make([]json.RawMessage, len(s.secrets)),
+	}
+	for i, c := range s.clusters {
+		data, err := marshalProtoJSON(c)
+		if err != nil {
+			return nil, err
+		}
+		p.Clusters[i] = data
+	}
+	for i, e := range s.endpoints {
+		data, err := marshalProtoJSON(e)
+		if err != nil {
+			return nil, err
+		}
+		p.Endpoints[i] = data
+	}
+	if s.route != nil {
+		data, err := marshalProtoJSON(s.route)
+		if err != nil {
+			return nil, err
+		}
+		p.Route = data
+	}
+	if s.listener != nil {
+		data, err := marshalProtoJSON(s.listener)
+		if err != nil {
+			return nil, err
+		}
+		p.Listener = data
+	}
+	for i, secret := range s.secrets {
+		data, err := marshalProtoJSON(secret)
+		if err != nil {
+			return nil, err
+		}
+		p.Secrets[i] = data
+	}
+	if s.runtime != nil {
+		data, err := marshalProtoJSON(s.runtime)
+		if err != nil {
+			return nil, err
+		}
+		p.Runtime = data
+	}
+	return p, nil
+}
+
+func newPersistedConfig(c *nodeGroupConfig) *persistedConfig {
+	p := &persistedConfig{
+		CDS:     c.cds,
+		EDS:     c.eds,
+		RDS:     c.rds,
+		LDS:     c.lds,
+		SDS:     c.sds,
+		Runtime: nil,
+	}
+	if c.runtime != nil {
+		p.Runtime = jsonValue(map[string]interface{}(c.runtime)).(map[string]interface{})
+	}
+	return p
+}
+
+func (p *persistedConfig) config() *nodeGroupConfig {
+	c := &nodeGroupConfig{
+		cds:     p.CDS,
+		eds:     p.EDS,
+		rds:     p.RDS,
+		lds:     p.LDS,
+		sds:     p.SDS,
+		runtime: nil,
+	}
+	if p.Runtime != nil {
+		c.runtime = RTDSConfig(p.Runtime)
+	}
+	return c
+}
+
+func persistFilePath(stateDir string, name string) string {
+	return filepath.Join(stateDir, url.PathEscape(name)+".json")
+}
+
+// saveSnapshot writes the snapshot atomically (write temp file then rename)
+func saveSnapshot(stateDir string, name string, state *resourceState, config *nodeGroupConfig) error {
+	resources, err := newPersistedResources(state)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(persistedSnapshot{
+		NodeGroup: name,
+		SavedAt:   time.Now(),
+		Version:   state.version(),
+		Resources: resources,
+		Config:    newPersistedConfig(config),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(stateDir, persistDirMode); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(stateDir, ".tmp-") // 0600
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), persistFilePath(stateDir, name))
+}
+
+func loadSnapshot(stateDir string, name string) (*persistedSnapshot, error) {
+	data, err := ioutil.ReadFile(persistFilePath(stateDir, name))
+	if err != nil {
+		return nil, err
+	}
+	p := new(persistedSnapshot)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -1,8 +1,6 @@
 package xds
 
 import (
-	"strconv"
-	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/ptypes"
@@ -67,11 +65,6 @@ func initRdsOpt(opt *rdsOpt) {
 type routeDiscoveryService struct {
 	opt       *rdsOpt
 	xdsConfig *corev3.ConfigSource
-	version   uint64
-}
-
-func (r *routeDiscoveryService) increVersion() uint64 {
-	return atomic.AddUint64(&r.version, 1)
 }
 
 func (r *routeDiscoveryService) retryBackOff() *routev3.RetryPolicy_RetryBackOff {
@@ -219,8 +212,12 @@ func (r *routeDiscoveryService) routeConfiguration(configs []RDSConfig) *routev3
 }
 
 func (r *routeDiscoveryService) create(configs []RDSConfig) (string, *routev3.RouteConfiguration, error) {
-	version := strconv.FormatUint(r.increVersion(), 10)
-	return version, r.routeConfiguration(configs), nil
+	route := r.routeConfiguration(configs)
+	version, err := resourceVersion(route)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, route, nil
 }
 
 func newRouteDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...rdsOptFunc) *routeDiscoveryService {
@@ -233,6 +230,5 @@ func newRouteDiscoveryService(xdsConfig *corev3.ConfigSource, funcs ...rdsOptFun
 	return &routeDiscoveryService{
 		opt:       opt,
 		xdsConfig: xdsConfig,
-		version:   uint64(0),
 	}
 }
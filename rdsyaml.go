@@ -5,36 +5,36 @@ import (
 )
 
 type RDSConfig struct {
-	VHostName string             `yaml:"vhost"   validate:"required"`
-	Domain    []string           `yaml:"domain"  validate:"required,unique"`
-	Cluster   []RDSClusterConfig `yaml:"cluster" validate:"required"`
-	Action    RDSActionConfig    `yaml:"action"  validate:"required"`
+	VHostName string             `yaml:"vhost"   json:"vhost"   validate:"required"`
+	Domain    []string           `yaml:"domain"  json:"domain"  validate:"required,unique"`
+	Cluster   []RDSClusterConfig `yaml:"cluster" json:"cluster" validate:"required"`
+	Action    RDSActionConfig    `yaml:"action"  json:"action"  validate:"required"`
 }
 
 type RDSClusterConfig struct {
-	Prefix  string                   `yaml:"prefix"  validate:"required"`
-	Target  []RDSClusterWeightConfig `yaml:"target"  validate:"required"`
-	Headers []RDSClusterHeaderConfig `yaml:"headers" validate:""`
+	Prefix  string                   `yaml:"prefix"  json:"prefix"  validate:"required"`
+	Target  []RDSClusterWeightConfig `yaml:"target"  json:"target"  validate:"required"`
+	Headers []RDSClusterHeaderConfig `yaml:"headers" json:"headers" validate:""`
 }
 
 type RDSClusterWeightConfig struct {
-	ClusterName string `yaml:"name"   validate:"required"`
-	Weight      uint32 `yaml:"weight" validate:"gte=0,lte=100"`
+	ClusterName string `yaml:"name"   json:"name"   validate:"required"`
+	Weight      uint32 `yaml:"weight" json:"weight" validate:"gte=0,lte=100"`
 }
 
 type RDSClusterHeaderConfig struct {
-	HeaderName  string           `yaml:"name"          validate:""`
-	StringMatch RDSStringMatcher `yaml:"string_match"  validate:""`
+	HeaderName  string           `yaml:"name"          json:"name"          validate:""`
+	StringMatch RDSStringMatcher `yaml:"string_match"  json:"string_match"  validate:""`
 }
 
 type RDSStringMatcher struct {
-	Exact string `yaml:"exact" validate:""`
+	Exact string `yaml:"exact" json:"exact" validate:""`
 }
 
 type RDSActionConfig struct {
-	Timeout     uint32 `yaml:"timeout"      validate:"required"`
-	IdleTimeout uint32 `yaml:"idle-timeout" validate:"required"`
-	RetryPolicy string `yaml:"retry-policy" validate:"required"`
+	Timeout     uint32 `yaml:"timeout"      json:"timeout"      validate:"required"`
+	IdleTimeout uint32 `yaml:"idle-timeout" json:"idle-timeout" validate:"required"`
+	RetryPolicy string `yaml:"retry-policy" json:"retry-policy" validate:"required"`
 }
 
 func (c RDSActionConfig) TimeoutSecond() time.Duration {
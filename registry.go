@@ -0,0 +1,418 @@
+package xds
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/peer"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+)
+
+const (
+	ProxySyncStatusSynced  string = "SYNCED"
+	ProxySyncStatusStale   string = "STALE"
+	ProxySyncStatusNacked  string = "NACKED"
+	ProxySyncStatusNotSent string = "NOT SENT"
+)
+
+// compile check
+var (
+	_ serverv3.Callbacks = (*proxyRegistry)(nil)
+)
+
+// ProxyStatus is the state of a connected envoy, aggregated over its xDS streams
+type ProxyStatus struct {
+	NodeId       string             `json:"node_id"`
+	Cluster      string             `json:"cluster"`
+	Region       string             `json:"region"`
+	Zone         string             `json:"zone"`
+	BuildVersion string             `json:"build_version"`
+	PeerAddr     []string           `json:"peer_addr"`
+	ConnectedAt  time.Time          `json:"connected_at"`
+	Types        []*ProxyTypeStatus `json:"types"`
+}
+
+// ProxyTypeStatus is the version sent/ACKed/NACKed of a type URL
+type ProxyTypeStatus struct {
+	Type          string    `json:"type"`
+	TypeURL       string    `json:"type_url"`
+	Delta         bool      `json:"delta"`
+	SentVersion   string    `json:"sent_version"`
+	AckedVersion  string    `json:"acked_version"`
+	NackedVersion string    `json:"nacked_version"`
+	NackError     string    `json:"nack_error"`
+	Status        string    `json:"status"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+func (t *ProxyTypeStatus) syncStatus() string {
+	switch {
+	case t.SentVersion == "":
+		return ProxySyncStatusNotSent
+	case t.NackedVersion != "" && t.NackedVersion == t.SentVersion:
+		return ProxySyncStatusNacked
+	case t.AckedVersion == t.SentVersion:
+		return ProxySyncStatusSynced
+	default:
+		return ProxySyncStatusStale
+	}
+}
+
+type proxyStreamKey struct {
+	delta bool
+	id    int64
+}
+
+type proxyStream struct {
+	node     *corev3.Node
+	typeURL  string // empty on ADS
+	peerAddr string
+	openedAt time.Time
+	types    map[string]*ProxyTypeStatus
+	nonces   map[string]sentResponse
+}
+
+type sentResponse struct {
+	version string
+	sentAt  time.Time
+}
+
+func (s *proxyStream) typeStatus(typeURL string, delta bool) *ProxyTypeStatus {
+	if t, ok := s.types[typeURL]; ok {
+		return t
+	}
+	t := &ProxyTypeStatus{
+		Type:    typeName(typeURL),
+		TypeURL: typeURL,
+		Delta:   delta,
+	}
+	s.types[typeURL] = t
+	return t
+}
+
+// proxyRegistry tracks the connected envoys through xDS stream callbacks
+type proxyRegistry struct {
+	mutex         *sync.RWMutex
+	streams       map[proxyStreamKey]*proxyStream
+	watch         *WatchFile
+	nackThreshold float64
+}
+
+func (r *proxyRegistry) open(ctx context.Context, key proxyStreamKey, typeURL string) {
+	peerAddr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		peerAddr = p.Addr.String()
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.streams[key] = &proxyStream{
+		typeURL:  typeURL,
+		peerAddr: peerAddr,
+		openedAt: time.Now(),
+		types:    make(map[string]*ProxyTypeStatus),
+		nonces:   make(map[string]sentResponse),
+	}
+	metricOpenStreams.WithLabelValues(streamTypeLabel(typeURL), strconv.FormatBool(key.delta)).Inc()
+}
+
+func (r *proxyRegistry) close(key proxyStreamKey) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.streams[key]
+	if ok != true {
+		return
+	}
+	delete(r.streams, key)
+	metricOpenStreams.WithLabelValues(streamTypeLabel(s.typeURL), strconv.FormatBool(key.delta)).Dec()
+}
+
+func (r *proxyRegistry) request(key proxyStreamKey, node *corev3.Node, typeURL string, nonce string, ackVersion string, errorDetail string) {
+	group, version, nacked := r.updateRequest(key, node, typeURL, nonce, ackVersion, errorDetail)
+	if nacked {
+		go r.rollbackNack(group, typeURL, version)
+	}
+}
+
+func (r *proxyRegistry) updateRequest(key proxyStreamKey, node *corev3.Node, typeURL string, nonce string, ackVersion string, errorDetail string) (string, string, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.streams[key]
+	if ok != true {
+		return "", "", false
+	}
+	if node != nil {
+		// set_node_on_first_message_only
+		s.node = node
+	}
+	if nonce == "" {
+		return "", "", false // initial request or new subscription
+	}
+
+	t := s.typeStatus(typeURL, key.delta)
+	version := ackVersion
+	if sent, ok := s.nonces[nonce]; ok {
+		version = sent.version
+		metricResponseAckSeconds.WithLabelValues(t.Type, ackResult(errorDetail)).Observe(time.Since(sent.sentAt).Seconds())
+	}
+	delete(s.nonces, nonce)
+
+	t.UpdatedAt = time.Now()
+	if errorDetail != "" {
+		t.NackedVersion = version
+		t.NackError = errorDetail
+		log.Printf(
+			"warn: NACK node=%s cluster=%s type=%s version=%s: %s",
+			s.node.GetId(), s.node.GetCluster(), t.Type, version, errorDetail,
+		)
+		return r.nodeGroupName(s.node), version, true
+	}
+	t.AckedVersion = version
+	return "", "", false
+}
+
+func (r *proxyRegistry) nodeGroupName(node *corev3.Node) string {
+	if r.watch == nil {
+		return ""
+	}
+	return r.watch.NodeGroupName(node)
+}
+
+// nackShare returns the number of nodes in group that rejected version of typeURL, and the number of nodes in group
+func (r *proxyRegistry) nackShare(group string, typeURL string, version string) (int, int) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	nodes := make(map[string]bool, len(r.streams))
+	for _, s := range r.streams {
+		if s.node == nil || r.nodeGroupName(s.node) != group {
+			continue
+		}
+
+		nodeId := s.node.GetId()
+		if _, ok := nodes[nodeId]; ok != true {
+			nodes[nodeId] = false
+		}
+		if t, ok := s.types[typeURL]; ok {
+			if t.NackedVersion == version && t.SentVersion == version {
+				nodes[nodeId] = true
+			}
+		}
+	}
+
+	nacked := 0
+	for _, ok := range nodes {
+		if ok {
+			nacked += 1
+		}
+	}
+	return nacked, len(nodes)
+}
+
+func (r *proxyRegistry) rollbackNack(group string, typeURL string, version string) {
+	if r.watch == nil || r.nackThreshold <= 0 {
+		return
+	}
+
+	nacked, total := r.nackShare(group, typeURL, version)
+	if total < 1 || float64(nacked)/float64(total) < r.nackThreshold {
+		log.Printf("info: xds %s %s version %s rejected by %d/%d nodes, rollback threshold %.2f", group, typeName(typeURL), version, nacked, total, r.nackThreshold)
+		return
+	}
+
+	log.Printf("warn: xds %s %s version %s rejected by %d/%d nodes, rollback to last-known-good snapshot", group, typeName(typeURL), version, nacked, total)
+	if err := r.watch.rollbackNack(group, typeURL, version); err != nil {
+		log.Printf("error: rollback failed: %s", err.Error())
+	}
+}
+
+func (r *proxyRegistry) response(key proxyStreamKey, typeURL string, nonce string, version string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.streams[key]
+	if ok != true {
+		return
+	}
+
+	t := s.typeStatus(typeURL, key.delta)
+	t.SentVersion = version
+	t.UpdatedAt = time.Now()
+	s.nonces[nonce] = sentResponse{version: version, sentAt: t.UpdatedAt}
+}
+
+func (r *proxyRegistry) OnStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	r.open(ctx, proxyStreamKey{delta: false, id: streamID}, typeURL)
+	return nil
+}
+
+func (r *proxyRegistry) OnStreamClosed(streamID int64, node *corev3.Node) {
+	r.close(proxyStreamKey{delta: false, id: streamID})
+}
+
+func (r *proxyRegistry) OnDeltaStreamOpen(ctx context.Context, streamID int64, typeURL string) error {
+	r.open(ctx, proxyStreamKey{delta: true, id: streamID}, typeURL)
+	return nil
+}
+
+func (r *proxyRegistry) OnDeltaStreamClosed(streamID int64, node *corev3.Node) {
+	r.close(proxyStreamKey{delta: true, id: streamID})
+}
+
+func (r *proxyRegistry) OnStreamRequest(streamID int64, req *discoveryv3.DiscoveryRequest) error {
+	r.request(
+		proxyStreamKey{delta: false, id: streamID},
+		req.GetNode(),
+		req.GetTypeUrl(),
+		req.GetResponseNonce(),
+		req.GetVersionInfo(),
+		req.GetErrorDetail().GetMessage(),
+	)
+	return nil
+}
+
+func (r *proxyRegistry) OnStreamResponse(ctx context.Context, streamID int64, req *discoveryv3.DiscoveryRequest, res *discoveryv3.DiscoveryResponse) {
+	r.response(proxyStreamKey{delta: false, id: streamID}, res.GetTypeUrl(), res.GetNonce(), res.GetVersionInfo())
+}
+
+func (r *proxyRegistry) OnStreamDeltaRequest(streamID int64, req *discoveryv3.DeltaDiscoveryRequest) error {
+	r.request(
+		proxyStreamKey{delta: true, id: streamID},
+		req.GetNode(),
+		req.GetTypeUrl(),
+		req.GetResponseNonce(),
+		"",
+		req.GetErrorDetail().GetMessage(),
+	)
+	return nil
+}
+
+func (r *proxyRegistry) OnStreamDeltaResponse(streamID int64, req *discoveryv3.DeltaDiscoveryRequest, res *discoveryv3.DeltaDiscoveryResponse) {
+	r.response(proxyStreamKey{delta: true, id: streamID}, res.GetTypeUrl(), res.GetNonce(), res.GetSystemVersionInfo())
+}
+
+func (r *proxyRegistry) OnFetchRequest(ctx context.Context, req *discoveryv3.DiscoveryRequest) error {
+	return nil
+}
+
+func (r *proxyRegistry) OnFetchResponse(req *discoveryv3.DiscoveryRequest, res *discoveryv3.DiscoveryResponse) {
+	// nop
+}
+
+// Proxies returns the status of connected envoys sorted by node id
+func (r *proxyRegistry) Proxies() []*ProxyStatus {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	proxies := make(map[string]*ProxyStatus, len(r.streams))
+	for _, s := range r.streams {
+		if s.node == nil {
+			continue // node not received yet
+		}
+
+		p, ok := proxies[s.node.GetId()]
+		if ok != true {
+			p = &ProxyStatus{
+				NodeId:       s.node.GetId(),
+				Cluster:      s.node.GetCluster(),
+				Region:       s.node.GetLocality().GetRegion(),
+				Zone:         s.node.GetLocality().GetZone(),
+				BuildVersion: buildVersion(s.node),
+				PeerAddr:     make([]string, 0),
+				ConnectedAt:  s.openedAt,
+				Types:        make([]*ProxyTypeStatus, 0),
+			}
+			proxies[p.NodeId] = p
+		}
+		if s.openedAt.Before(p.ConnectedAt) {
+			p.ConnectedAt = s.openedAt
+		}
+		p.PeerAddr = appendUnique(p.PeerAddr, s.peerAddr)
+		for _, t := range s.types {
+			copied := *t
+			copied.Status = copied.syncStatus()
+			p.Types = append(p.Types, &copied)
+		}
+	}
+
+	list := make([]*ProxyStatus, 0, len(proxies))
+	for _, p := range proxies {
+		sort.Slice(p.Types, func(i, j int) bool {
+			return p.Types[i].TypeURL < p.Types[j].TypeURL
+		})
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].NodeId < list[j].NodeId
+	})
+	return list
+}
+
+func newProxyRegistry(watch *WatchFile, nackThreshold float64) *proxyRegistry {
+	return &proxyRegistry{
+		mutex:         new(sync.RWMutex),
+		streams:       make(map[proxyStreamKey]*proxyStream),
+		watch:         watch,
+		nackThreshold: nackThreshold,
+	}
+}
+
+func buildVersion(node *corev3.Node) string {
+	if v := node.GetUserAgentBuildVersion().GetVersion(); v != nil {
+		return fmt.Sprintf("%s/%d.%d.%d", node.GetUserAgentName(), v.GetMajorNumber(), v.GetMinorNumber(), v.GetPatch())
+	}
+	if node.GetUserAgentVersion() != "" {
+		return node.GetUserAgentName() + "/" + node.GetUserAgentVersion()
+	}
+	return node.GetUserAgentName()
+}
+
+func typeName(typeURL string) string {
+	switch typeURL {
+	case resourcev3.ClusterType:
+		return "CDS"
+	case resourcev3.EndpointType:
+		return "EDS"
+	case resourcev3.ListenerType:
+		return "LDS"
+	case resourcev3.RouteType:
+		return "RDS"
+	case resourcev3.SecretType:
+		return "SDS"
+	case resourcev3.RuntimeType:
+		return "RTDS"
+	default:
+		return typeURL
+	}
+}
+
+func ackResult(errorDetail string) string {
+	if errorDetail != "" {
+		return "nack"
+	}
+	return "ack"
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,274 @@
+package xds
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	httpconnmgrv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+type renderOptFunc func(*renderOpt)
+
+type renderOpt struct {
+	format  string
+	xds     bool
+	secrets bool
+	alsAddr string
+}
+
+// RenderFormat is BootstrapFormatYAML or BootstrapFormatJSON
+func RenderFormat(format string) renderOptFunc {
+	return func(opt *renderOpt) {
+		opt.format = format
+	}
+}
+
+// RenderXds renders the resources as served over xDS instead of a static config
+func RenderXds(enable bool) renderOptFunc {
+	return func(opt *renderOpt) {
+		opt.xds = enable
+	}
+}
+
+// RenderSecrets includes the secrets(private keys) of sds.yaml
+func RenderSecrets(enable bool) renderOptFunc {
+	return func(opt *renderOpt) {
+		opt.secrets = enable
+	}
+}
+
+// RenderAlsAddr is the host:port of the als server that the access log of the static listener sends to
+func RenderAlsAddr(addr string) renderOptFunc {
+	return func(opt *renderOpt) {
+		opt.alsAddr = addr
+	}
+}
+
+func initRenderOpt(opt *renderOpt) {
+	if opt.format == "" {
+		opt.format = BootstrapFormatYAML
+	}
+	if opt.alsAddr == "" {
+		opt.alsAddr = defaultBootstrapAlsAddr
+	}
+}
+
+// xdsResources is the snapshot as served over xDS, grouped by type
+type xdsResources struct {
+	Clusters  []json.RawMessage `json:"clusters"`
+	Endpoints []json.RawMessage `json:"endpoints"`
+	Routes    []json.RawMessage `json:"routes"`
+	Listeners []json.RawMessage `json:"listeners"`
+	Secrets   []json.RawMessage `json:"secrets,omitempty"`
+	Runtimes  []json.RawMessage `json:"runtimes,omitempty"`
+}
+
+// Render generates the snapshot from the files of group without publishing it, and renders
+// it as envoy bootstrap static_resources(and layered_runtime) with EDS and RDS inlined.
+// BootstrapAlsClusterName that the access log refers to is added with RenderAlsAddr.
+func (w *WatchFile) Render(groupName string, funcs ...renderOptFunc) ([]byte, error) {
+	opt := new(renderOpt)
+	for _, fn := range funcs {
+		fn(opt)
+	}
+	initRenderOpt(opt)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	g, ok := w.findGroup(groupName)
+	if ok != true {
+		return nil, fmt.Errorf("node group '%s' not found", groupName)
+	}
+	r, errs := w.buildGroup(g)
+	if 0 < len(errs) {
+		return nil, errs
+	}
+	state := r.state()
+	if opt.secrets != true {
+		state.secrets = nil
+	}
+
+	if opt.xds {
+		return renderXds(state, opt.format)
+	}
+	b, err := staticBootstrap(state, opt.alsAddr)
+	if err != nil {
+		return nil, err
+	}
+	return MarshalBootstrap(b, opt.format)
+}
+
+func staticBootstrap(s *resourceState, alsAddr string) (*bootstrapv3.Bootstrap, error) {
+	clusters, err := staticClusters(s.clusters, s.endpoints)
+	if err != nil {
+		return nil, err
+	}
+	alsCluster, err := bootstrapAlsCluster(&bootstrapOpt{alsAddr: alsAddr})
+	if err != nil {
+		return nil, err
+	}
+	listener, err := staticListener(s.listener, s.route)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bootstrapv3.Bootstrap{
+		StaticResources: &bootstrapv3.Bootstrap_StaticResources{
+			Listeners: []*listenerv3.Listener{listener},
+			Clusters:  append(clusters, alsCluster),
+			Secrets:   s.secrets,
+		},
+	}
+	if s.runtime != nil {
+		b.LayeredRuntime = &bootstrapv3.LayeredRuntime{
+			Layers: []*bootstrapv3.RuntimeLayer{
+				&bootstrapv3.RuntimeLayer{
+					Name: s.runtime.GetName(),
+					LayerSpecifier: &bootstrapv3.RuntimeLayer_StaticLayer{
+						StaticLayer: s.runtime.GetLayer(),
+					},
+				},
+			},
+		}
+	}
+	if err := b.ValidateAll(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// staticClusters replaces eds_cluster_config with load_assignment of the same cluster
+func staticClusters(clusters []*clusterv3.Cluster, endpoints []*endpointv3.ClusterLoadAssignment) ([]*clusterv3.Cluster, error) {
+	assignments := make(map[string]*endpointv3.ClusterLoadAssignment, len(endpoints))
+	for _, e := range endpoints {
+		assignments[e.GetClusterName()] = e
+	}
+
+	static := make([]*clusterv3.Cluster, len(clusters))
+	for i, c := range clusters {
+		edsName := c.GetEdsClusterConfig().GetServiceName()
+		assignment, ok := assignments[edsName]
+		if ok != true {
+			return nil, fmt.Errorf("cluster '%s' has no endpoints named '%s'", c.GetName(), edsName)
+		}
+		sc := proto.Clone(c).(*clusterv3.Cluster)
+		sc.ClusterDiscoveryType = &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC}
+		sc.EdsClusterConfig = nil
+		sc.LoadAssignment = proto.Clone(assignment).(*endpointv3.ClusterLoadAssignment)
+		sc.LoadAssignment.ClusterName = sc.GetName()
+		static[i] = sc
+	}
+	return static, nil
+}
+
+// staticListener replaces rds of http_connection_manager with route_config
+func staticListener(listener *listenerv3.Listener, route *routev3.RouteConfiguration) (*listenerv3.Listener, error) {
+	sl := proto.Clone(listener).(*listenerv3.Listener)
+	for _, chain := range sl.GetFilterChains() {
+		for _, filter := range chain.GetFilters() {
+			typedConfig := filter.GetTypedConfig()
+			if typedConfig == nil {
+				continue
+			}
+			manager := new(httpconnmgrv3.HttpConnectionManager)
+			if ptypes.Is(typedConfig, manager) != true {
+				continue
+			}
+			if err := ptypes.UnmarshalAny(typedConfig, manager); err != nil {
+				return nil, err
+			}
+			if manager.GetRds().GetRouteConfigName() != route.GetName() {
+				return nil, fmt.Errorf("listener '%s' refers route '%s' not found", sl.GetName(), manager.GetRds().GetRouteConfigName())
+			}
+			manager.RouteSpecifier = &httpconnmgrv3.HttpConnectionManager_RouteConfig{
+				RouteConfig: route,
+			}
+			managerConfig, err := ptypes.MarshalAny(manager)
+			if err != nil {
+				return nil, err
+			}
+			filter.ConfigType = &listenerv3.Filter_TypedConfig{TypedConfig: managerConfig}
+		}
+	}
+	return sl, nil
+}
+
+func renderXds(s *resourceState, format string) ([]byte, error) {
+	var err error
+	res := xdsResources{}
+	if res.Clusters, err = renderMessages(clusterMessageList(s.clusters)...); err != nil {
+		return nil, err
+	}
+	if res.Endpoints, err = renderMessages(endpointMessageList(s.endpoints)...); err != nil {
+		return nil, err
+	}
+	if res.Routes, err = renderMessages(s.route); err != nil {
+		return nil, err
+	}
+	if res.Listeners, err = renderMessages(s.listener); err != nil {
+		return nil, err
+	}
+	if 0 < len(s.secrets) {
+		if res.Secrets, err = renderMessages(secretMessageList(s.secrets)...); err != nil {
+			return nil, err
+		}
+	}
+	if s.runtime != nil {
+		if res.Runtimes, err = renderMessages(s.runtime); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return convertFormat(data, format)
+}
+
+func renderMessages(msgs ...proto.Message) ([]json.RawMessage, error) {
+	values := make([]json.RawMessage, len(msgs))
+	for i, msg := range msgs {
+		data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = json.RawMessage(data)
+	}
+	return values, nil
+}
+
+func clusterMessageList(clusters []*clusterv3.Cluster) []proto.Message {
+	msgs := make([]proto.Message, len(clusters))
+	for i, c := range clusters {
+		msgs[i] = c
+	}
+	return msgs
+}
+
+func endpointMessageList(endpoints []*endpointv3.ClusterLoadAssignment) []proto.Message {
+	msgs := make([]proto.Message, len(endpoints))
+	for i, e := range endpoints {
+		msgs[i] = e
+	}
+	return msgs
+}
+
+func secretMessageList(secrets []*tlsv3.Secret) []proto.Message {
+	msgs := make([]proto.Message, len(secrets))
+	for i, secret := range secrets {
+		msgs[i] = secret
+	}
+	return msgs
+}
@@ -0,0 +1,79 @@
+package xds
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+// TestRenderClusterReferences checks that the rendered static config defines every cluster it refers to,
+// so that it can be pasted into envoy as is
+func TestRenderClusterReferences(t *testing.T) {
+	for _, dir := range []string{".", filepath.Join("testdata", "variants")} {
+		t.Run(dir, func(tc *testing.T) {
+			w := NewWatchFile(context.Background(),
+				WatchCdsConfigFile(filepath.Join(dir, NodeGroupCdsFileName)),
+				WatchEdsConfigFile(filepath.Join(dir, NodeGroupEdsFileName)),
+				WatchRdsConfigFile(filepath.Join(dir, NodeGroupRdsFileName)),
+				WatchLdsConfigFile(filepath.Join(dir, NodeGroupLdsFileName)),
+			)
+			data, err := w.Render(DefaultNodeGroupName, RenderFormat(BootstrapFormatJSON))
+			if err != nil {
+				tc.Fatalf("render: %s", err.Error())
+			}
+
+			b := struct {
+				StaticResources struct {
+					Listeners []interface{} `json:"listeners"`
+					Clusters  []struct {
+						Name string `json:"name"`
+					} `json:"clusters"`
+				} `json:"static_resources"`
+			}{}
+			if err := json.Unmarshal(data, &b); err != nil {
+				tc.Fatalf("unmarshal: %s", err.Error())
+			}
+			defined := make(map[string]bool, len(b.StaticResources.Clusters))
+			for _, c := range b.StaticResources.Clusters {
+				defined[c.Name] = true
+			}
+
+			refs := make([]string, 0)
+			testClusterRefs(b.StaticResources.Listeners, &refs)
+			if len(refs) < 1 {
+				tc.Fatalf("listener must refer clusters")
+			}
+			for _, name := range refs {
+				if defined[name] != true {
+					tc.Errorf("cluster '%s' is referenced but not defined", name)
+				}
+			}
+		})
+	}
+}
+
+// testClusterRefs collects cluster names of route actions(cluster, weighted_clusters) and grpc services(cluster_name)
+func testClusterRefs(v interface{}, refs *[]string) {
+	switch value := v.(type) {
+	case []interface{}:
+		for _, e := range value {
+			testClusterRefs(e, refs)
+		}
+	case map[string]interface{}:
+		for key, e := range value {
+			switch key {
+			case "cluster", "cluster_name":
+				if name, ok := e.(string); ok {
+					*refs = append(*refs, name)
+				}
+			case "weighted_clusters":
+				for _, c := range e.(map[string]interface{})["clusters"].([]interface{}) {
+					*refs = append(*refs, c.(map[string]interface{})["name"].(string))
+				}
+				continue
+			}
+			testClusterRefs(e, refs)
+		}
+	}
+}
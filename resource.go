@@ -1,13 +1,14 @@
 package xds
 
 import (
-	"strings"
 	"sync"
 
 	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	runtimev3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
 	typesv3 "github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
@@ -23,6 +24,13 @@ type resource struct {
 	routeVersion     string
 	listener         *listenerv3.Listener
 	listenerVersion  string
+	secrets          []*tlsv3.Secret
+	secretsVersion   string
+	runtime          *runtimev3.Runtime
+	runtimeVersion   string
+	history          []*historyEntry // newest first
+	historySize      int
+	historySeq       uint64
 }
 
 func (r *resource) updateListener(version string, listener *listenerv3.Listener) {
@@ -85,12 +93,187 @@ func (r *resource) currentEndpoint() (string, []*endpointv3.ClusterLoadAssignmen
 	return r.endpointsVersion, r.endpoints
 }
 
+func (r *resource) updateSecret(version string, secrets []*tlsv3.Secret) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.secretsVersion = version
+	r.secrets = secrets
+}
+
+func (r *resource) currentSecret() (string, []*tlsv3.Secret) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.secretsVersion, r.secrets
+}
+
+func (r *resource) updateRuntime(version string, runtime *runtimev3.Runtime) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.runtimeVersion = version
+	r.runtime = runtime
+}
+
+func (r *resource) currentRuntime() (string, *runtimev3.Runtime) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.runtimeVersion, r.runtime
+}
+
+// resourceState is a copy of the resources published as a snapshot
+type resourceState struct {
+	endpoints        []*endpointv3.ClusterLoadAssignment
+	endpointsVersion string
+	clusters         []*clusterv3.Cluster
+	clustersVersion  string
+	route            *routev3.RouteConfiguration
+	routeVersion     string
+	listener         *listenerv3.Listener
+	listenerVersion  string
+	secrets          []*tlsv3.Secret
+	secretsVersion   string
+	runtime          *runtimev3.Runtime
+	runtimeVersion   string
+}
+
+func (s *resourceState) version() string {
+	return versionString(
+		s.endpointsVersion,
+		s.clustersVersion,
+		s.routeVersion,
+		s.listenerVersion,
+		s.secretsVersion,
+		s.runtimeVersion,
+	)
+}
+
+func (s *resourceState) typeVersion(typeURL string) string {
+	switch typeURL {
+	case resourcev3.EndpointType:
+		return s.endpointsVersion
+	case resourcev3.ClusterType:
+		return s.clustersVersion
+	case resourcev3.RouteType:
+		return s.routeVersion
+	case resourcev3.ListenerType:
+		return s.listenerVersion
+	case resourcev3.SecretType:
+		return s.secretsVersion
+	case resourcev3.RuntimeType:
+		return s.runtimeVersion
+	default:
+		return ""
+	}
+}
+
+func (r *resource) state() *resourceState {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return &resourceState{
+		endpoints:        r.endpoints,
+		endpointsVersion: r.endpointsVersion,
+		clusters:         r.clusters,
+		clustersVersion:  r.clustersVersion,
+		route:            r.route,
+		routeVersion:     r.routeVersion,
+		listener:         r.listener,
+		listenerVersion:  r.listenerVersion,
+		secrets:          r.secrets,
+		secretsVersion:   r.secretsVersion,
+		runtime:          r.runtime,
+		runtimeVersion:   r.runtimeVersion,
+	}
+}
+
+func (r *resource) restore(s *resourceState) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.endpoints = s.endpoints
+	r.endpointsVersion = s.endpointsVersion
+	r.clusters = s.clusters
+	r.clustersVersion = s.clustersVersion
+	r.route = s.route
+	r.routeVersion = s.routeVersion
+	r.listener = s.listener
+	r.listenerVersion = s.listenerVersion
+	r.secrets = s.secrets
+	r.secretsVersion = s.secretsVersion
+	r.runtime = s.runtime
+	r.runtimeVersion = s.runtimeVersion
+}
+
+func (r *resource) setHistorySize(size int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.historySize = size
+}
+
+// recordHistory adds the applied state, the oldest entry is dropped over historySize
+func (r *resource) recordHistory(source string, state *resourceState, config nodeGroupConfig, checksums map[string]string) *HistoryEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var prev *resourceState
+	if 0 < len(r.history) {
+		prev = r.history[0].state
+	}
+	r.historySeq += 1
+	h := newHistoryEntry(r.historySeq, source, state, config, checksums, prev)
+
+	r.history = append([]*historyEntry{h}, r.history...)
+	if r.historySize < len(r.history) {
+		r.history = r.history[:r.historySize]
+	}
+	return h.entry
+}
+
+func (r *resource) historyEntries() []*historyEntry {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	entries := make([]*historyEntry, len(r.history))
+	copy(entries, r.history)
+	return entries
+}
+
+func (r *resource) findHistory(id uint64) (*historyEntry, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, h := range r.history {
+		if h.entry.Id == id {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
+func (r *resource) findHistoryVersion(version string) (*historyEntry, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	for _, h := range r.history {
+		if h.entry.Version == version {
+			return h, true
+		}
+	}
+	return nil, false
+}
+
 func (r *resource) version() string {
 	return versionString(
 		r.endpointsVersion,
 		r.clustersVersion,
 		r.routeVersion,
 		r.listenerVersion,
+		r.secretsVersion,
+		r.runtimeVersion,
 	)
 }
 
@@ -108,6 +291,16 @@ func (r *resource) Snapshot() (string, *cachev3.Snapshot, error) {
 		clusters[i] = c
 	}
 
+	secrets := make([]typesv3.Resource, len(r.secrets))
+	for i, s := range r.secrets {
+		secrets[i] = s
+	}
+
+	runtimes := make([]typesv3.Resource, 0, 1)
+	if r.runtime != nil {
+		runtimes = append(runtimes, r.runtime)
+	}
+
 	version := r.version()
 
 	snapshot, err := cachev3.NewSnapshot(
@@ -117,12 +310,27 @@ func (r *resource) Snapshot() (string, *cachev3.Snapshot, error) {
 			resourcev3.ClusterType:  clusters,
 			resourcev3.RouteType:    []typesv3.Resource{r.route},
 			resourcev3.ListenerType: []typesv3.Resource{r.listener},
+			resourcev3.SecretType:   secrets,
+			resourcev3.RuntimeType:  runtimes,
 		},
 	)
 	if err != nil {
 		return "", &cachev3.Snapshot{}, err
 	}
 
+	// version of each type, envoy receives only the changed types
+	typeVersions := map[resourcev3.Type]string{
+		resourcev3.EndpointType: r.endpointsVersion,
+		resourcev3.ClusterType:  r.clustersVersion,
+		resourcev3.RouteType:    r.routeVersion,
+		resourcev3.ListenerType: r.listenerVersion,
+		resourcev3.SecretType:   r.secretsVersion,
+		resourcev3.RuntimeType:  r.runtimeVersion,
+	}
+	for typeURL, v := range typeVersions {
+		snapshot.Resources[cachev3.GetResponseType(typeURL)].Version = v
+	}
+
 	// validate variables
 	if err := snapshot.Consistent(); err != nil {
 		return "", &cachev3.Snapshot{}, err
@@ -141,9 +349,16 @@ func newResource() *resource {
 		routeVersion:     "0",
 		listener:         nil,
 		listenerVersion:  "0",
+		secrets:          nil,
+		secretsVersion:   "0",
+		runtime:          nil,
+		runtimeVersion:   "0",
+		history:          make([]*historyEntry, 0),
+		historySize:      defaultHistorySize,
+		historySeq:       0,
 	}
 }
 
-func versionString(endpoint, cluster, route, listener string) string {
-	return strings.Join([]string{endpoint, cluster, route, listener}, ".")
+func versionString(endpoint, cluster, route, listener, secret, runtime string) string {
+	return snapshotVersion(endpoint, cluster, route, listener, secret, runtime)
 }
@@ -0,0 +1,90 @@
+package xds
+
+import (
+	"fmt"
+
+	structpb "github.com/golang/protobuf/ptypes/struct"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	runtimev3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+)
+
+type runtimeDiscoveryService struct {
+	xdsConfig *corev3.ConfigSource
+}
+
+func (r *runtimeDiscoveryService) value(key string, v interface{}) (*structpb.Value, error) {
+	switch t := v.(type) {
+	case nil:
+		return &structpb.Value{Kind: &structpb.Value_NullValue{}}, nil
+	case bool:
+		return &structpb.Value{Kind: &structpb.Value_BoolValue{BoolValue: t}}, nil
+	case int:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: float64(t)}}, nil
+	case uint64:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: float64(t)}}, nil
+	case float64:
+		return &structpb.Value{Kind: &structpb.Value_NumberValue{NumberValue: t}}, nil
+	case string:
+		return &structpb.Value{Kind: &structpb.Value_StringValue{StringValue: t}}, nil
+	case map[interface{}]interface{}:
+		// runtime_fraction as FractionalPercent: {numerator: 10, denominator: HUNDRED}
+		fields := make(map[string]*structpb.Value, len(t))
+		for k, sub := range t {
+			name := fmt.Sprint(k)
+			if name != "numerator" && name != "denominator" {
+				return nil, fmt.Errorf("runtime key %s: unsupported field '%s', only numerator/denominator", key, name)
+			}
+			value, err := r.value(key+"."+name, sub)
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = value
+		}
+		return &structpb.Value{Kind: &structpb.Value_StructValue{StructValue: &structpb.Struct{Fields: fields}}}, nil
+	default:
+		return nil, fmt.Errorf("runtime key %s: unsupported value type %T", key, v)
+	}
+}
+
+func (r *runtimeDiscoveryService) layer(config RTDSConfig) (*structpb.Struct, error) {
+	fields := make(map[string]*structpb.Value, len(config))
+	for key, v := range config {
+		value, err := r.value(key, v)
+		if err != nil {
+			return nil, err
+		}
+		fields[key] = value
+	}
+	return &structpb.Struct{Fields: fields}, nil
+}
+
+func (r *runtimeDiscoveryService) runtime(config RTDSConfig) (*runtimev3.Runtime, error) {
+	layer, err := r.layer(config)
+	if err != nil {
+		return nil, err
+	}
+	// ref: envoy layered_runtime.layers.rtds_layer.name
+	return &runtimev3.Runtime{
+		Name:  BootstrapRuntimeLayerName,
+		Layer: layer,
+	}, nil
+}
+
+func (r *runtimeDiscoveryService) create(config RTDSConfig) (string, *runtimev3.Runtime, error) {
+	runtime, err := r.runtime(config)
+	if err != nil {
+		return "", nil, err
+	}
+	version, err := resourceVersion(runtime)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, runtime, nil
+}
+
+func newRuntimeDiscoveryService(xdsConfig *corev3.ConfigSource) *runtimeDiscoveryService {
+	return &runtimeDiscoveryService{
+		xdsConfig: xdsConfig,
+	}
+}
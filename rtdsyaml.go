@@ -0,0 +1,5 @@
+package xds
+
+// RTDSConfig is a flat key/value map of runtime layer.
+// value is string, number, bool or {numerator, denominator} of runtime_fraction
+type RTDSConfig map[string]interface{}
@@ -0,0 +1,112 @@
+package xds
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"google.golang.org/protobuf/proto"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+)
+
+// secretDiscoveryService serves the secrets of sds.yaml, the generated clusters and listener do not refer them.
+// envoy requests a secret only when a resource of its bootstrap refers it by name with sds_config
+type secretDiscoveryService struct{}
+
+func (s *secretDiscoveryService) inlineBytes(file string) (*corev3.DataSource, error) {
+	// read PEM here and send inline, envoy does not need to share the filesystem
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return &corev3.DataSource{
+		Specifier: &corev3.DataSource_InlineBytes{
+			InlineBytes: data,
+		},
+	}, nil
+}
+
+func (s *secretDiscoveryService) tlsCertificate(cfg SDSConfig) (*tlsv3.Secret_TlsCertificate, error) {
+	// https://www.envoyproxy.io/docs/envoy/v1.28.0/api-v3/extensions/transport_sockets/tls/v3/common.proto#envoy-v3-api-msg-extensions-transport-sockets-tls-v3-tlscertificate
+	cert, err := s.inlineBytes(cfg.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.inlineBytes(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsv3.Secret_TlsCertificate{
+		TlsCertificate: &tlsv3.TlsCertificate{
+			CertificateChain: cert,
+			PrivateKey:       key,
+		},
+	}, nil
+}
+
+func (s *secretDiscoveryService) validationContext(cfg SDSConfig) (*tlsv3.Secret_ValidationContext, error) {
+	// https://www.envoyproxy.io/docs/envoy/v1.28.0/api-v3/extensions/transport_sockets/tls/v3/common.proto#envoy-v3-api-msg-extensions-transport-sockets-tls-v3-certificatevalidationcontext
+	ca, err := s.inlineBytes(cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsv3.Secret_ValidationContext{
+		ValidationContext: &tlsv3.CertificateValidationContext{
+			TrustedCa: ca,
+		},
+	}, nil
+}
+
+func (s *secretDiscoveryService) secret(cfg SDSConfig) (*tlsv3.Secret, error) {
+	// ref: envoy sds_config.name
+	secretName := xdsName("example-xds-secret", cfg.SecretName)
+	if cfg.IsTlsCertificate() {
+		if cfg.CAFile != "" {
+			return nil, fmt.Errorf("secret %s: cert/key and ca are exclusive", cfg.SecretName)
+		}
+		cert, err := s.tlsCertificate(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return &tlsv3.Secret{Name: secretName, Type: cert}, nil
+	}
+
+	validation, err := s.validationContext(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsv3.Secret{Name: secretName, Type: validation}, nil
+}
+
+func (s *secretDiscoveryService) secrets(configs []SDSConfig) ([]*tlsv3.Secret, error) {
+	secrets := make([]*tlsv3.Secret, len(configs))
+	for idx, config := range configs {
+		secret, err := s.secret(config)
+		if err != nil {
+			return nil, err
+		}
+		secrets[idx] = secret
+	}
+	return secrets, nil
+}
+
+func (s *secretDiscoveryService) create(configs []SDSConfig) (string, []*tlsv3.Secret, error) {
+	secrets, err := s.secrets(configs)
+	if err != nil {
+		return "", nil, err
+	}
+	resources := make([]proto.Message, len(secrets))
+	for i, secret := range secrets {
+		resources[i] = secret
+	}
+	version, err := resourceVersion(resources...)
+	if err != nil {
+		return "", nil, err
+	}
+	return version, secrets, nil
+}
+
+func newSecretDiscoveryService() *secretDiscoveryService {
+	return &secretDiscoveryService{}
+}
@@ -0,0 +1,19 @@
+package xds
+
+type SDSConfig struct {
+	SecretName string `yaml:"name"  json:"name"  validate:"required"`
+	CertFile   string `yaml:"cert"  json:"cert"  validate:"required_without=CAFile,required_with=KeyFile"`
+	KeyFile    string `yaml:"key"   json:"key"   validate:"required_with=CertFile"`
+	CAFile     string `yaml:"ca"    json:"ca"    validate:""`
+}
+
+func (c SDSConfig) IsTlsCertificate() bool {
+	return c.CertFile != "" && c.KeyFile != ""
+}
+
+func (c SDSConfig) Files() []string {
+	if c.IsTlsCertificate() {
+		return []string{c.CertFile, c.KeyFile}
+	}
+	return []string{c.CAFile}
+}
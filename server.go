@@ -2,10 +2,16 @@ package xds
 
 import (
 	"context"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	alsv3 "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
 	clusterservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
@@ -28,10 +34,26 @@ const (
 
 type serverOptFunc func(*serverOpt)
 
+const (
+	defaultAdminReadTimeout         time.Duration = 10 * time.Second
+	defaultShutdownTimeout          time.Duration = 10 * time.Second
+	defaultAccessLogFlushInterval   time.Duration = 1 * time.Second
+	defaultAccessLogWriteBufferSize int           = 64 * 1024
+)
+
 type serverOpt struct {
 	xdsListenAddr        string
 	alsListenAddr        string
+	adminListenAddr      string
+	adminWrite           bool
 	maxConcurrentStreams uint32
+	tlsCertFile          string
+	tlsKeyFile           string
+	tlsClientCAFile      string
+	watch                *WatchFile
+	nackThreshold        float64
+	shutdownTimeout      time.Duration
+	accessLogOutput      io.Writer
 }
 
 func XdsListenAddr(addr string) serverOptFunc {
@@ -46,12 +68,73 @@ func AlsListenAddr(addr string) serverOptFunc {
 	}
 }
 
+// AdminListenAddr enables admin http api, disabled if empty
+func AdminListenAddr(addr string) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.adminListenAddr = addr
+	}
+}
+
+// AdminWriteEnable serves the admin api that changes the served snapshots(history rollback, pin, unpin)
+// or builds the posted yaml(diff), disabled by default as the admin api has no authentication
+func AdminWriteEnable(enable bool) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.adminWrite = enable
+	}
+}
+
 func MaxConcurrentStreams(n uint32) serverOptFunc {
 	return func(opt *serverOpt) {
 		opt.maxConcurrentStreams = n
 	}
 }
 
+// TLSCertFile enables TLS on xds and als listeners
+func TLSCertFile(certFile, keyFile string) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.tlsCertFile = certFile
+		opt.tlsKeyFile = keyFile
+	}
+}
+
+// TLSClientCAFile requires client certificate signed by CA (mTLS)
+func TLSClientCAFile(caFile string) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.tlsClientCAFile = caFile
+	}
+}
+
+// ServerWatchFile exposes snapshots and config files of watch on admin api,
+// it is also required to rollback on NACK
+func ServerWatchFile(watch *WatchFile) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.watch = watch
+	}
+}
+
+// RollbackOnNack restores the last-known-good snapshot of a node group
+// when the share of its nodes rejecting the published version reaches threshold (0 < threshold <= 1)
+func RollbackOnNack(threshold float64) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.nackThreshold = threshold
+	}
+}
+
+// ShutdownTimeout is the deadline to wait in-flight streams on Stop,
+// remaining streams are closed after that
+func ShutdownTimeout(dur time.Duration) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.shutdownTimeout = dur
+	}
+}
+
+// AccessLogOutput is the destination of access logs received from envoy(default stdout)
+func AccessLogOutput(out io.Writer) serverOptFunc {
+	return func(opt *serverOpt) {
+		opt.accessLogOutput = out
+	}
+}
+
 func initOpt(opt *serverOpt) {
 	if len(opt.xdsListenAddr) < 1 {
 		opt.xdsListenAddr = defaultXdsListenAddr
@@ -62,14 +145,25 @@ func initOpt(opt *serverOpt) {
 	if opt.maxConcurrentStreams < 1 {
 		opt.maxConcurrentStreams = defaultGrpcConcurrentStreams
 	}
+	if opt.shutdownTimeout < 1 {
+		opt.shutdownTimeout = defaultShutdownTimeout
+	}
+	if opt.accessLogOutput == nil {
+		opt.accessLogOutput = os.Stdout
+	}
 }
 
 type server struct {
+	ctx        context.Context
 	opt        *serverOpt
+	tls        *tlsReloader
 	xdsSvr     *grpc.Server
 	alsSvr     *grpc.Server
+	adminSvr   *http.Server
 	xdsHandler serverv3.Server
 	alsHandler *accesslogServiceHandler
+	acclog     *accessLogWriter
+	registry   *proxyRegistry
 }
 
 func (s *server) registerXdsService() {
@@ -108,7 +202,34 @@ func (s *server) listenAls() (net.Listener, error) {
 	return listener, nil
 }
 
+func (s *server) listenAdmin() (net.Listener, error) {
+	log.Printf("info: admin server listen: %s", s.opt.adminListenAddr)
+	listener, err := net.Listen("tcp", s.opt.adminListenAddr)
+	if err != nil {
+		log.Printf("error: addr '%s' listen error: %s", s.opt.adminListenAddr, err.Error())
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Proxies returns the status of connected envoys
+func (s *server) Proxies() []*ProxyStatus {
+	return s.registry.Proxies()
+}
+
 func (s *server) Start() error {
+	if s.tls != nil {
+		log.Printf("info: tls enabled cert=%s key=%s client-ca=%s", s.opt.tlsCertFile, s.opt.tlsKeyFile, s.opt.tlsClientCAFile)
+		if err := s.tls.load(); err != nil {
+			log.Printf("error: tls load error: %s", err.Error())
+			return err
+		}
+		if err := s.tls.Watch(s.ctx); err != nil {
+			log.Printf("error: tls watch error: %s", err.Error())
+			return err
+		}
+	}
+
 	xdsListen, err := s.listenXds()
 	if err != nil {
 		return err
@@ -121,31 +242,88 @@ func (s *server) Start() error {
 	s.registerXdsService()
 	s.registerAlsService()
 
-	errors := make(chan error, 0)
+	go s.acclog.flushLoop(s.ctx, defaultAccessLogFlushInterval)
+
+	// nil after Stop
+	errors := make(chan error, 3)
 	go func() {
-		if err := s.xdsSvr.Serve(xdsListen); err != nil {
+		err := s.xdsSvr.Serve(xdsListen)
+		if err != nil {
 			log.Printf("error: xds serve error: %s", err.Error())
-			errors <- err
 		}
+		errors <- err
 	}()
 	go func() {
-		if err := s.alsSvr.Serve(alsListen); err != nil {
+		err := s.alsSvr.Serve(alsListen)
+		if err != nil {
 			log.Printf("error: als serve error: %s", err.Error())
-			errors <- err
 		}
+		errors <- err
 	}()
+	if s.adminSvr != nil {
+		adminListen, err := s.listenAdmin()
+		if err != nil {
+			return err
+		}
+		go func() {
+			err := s.adminSvr.Serve(adminListen)
+			if err == http.ErrServerClosed {
+				err = nil
+			}
+			if err != nil {
+				log.Printf("error: admin serve error: %s", err.Error())
+			}
+			errors <- err
+		}()
+	}
 	return <-errors
 }
 
+// Stop waits in-flight streams until shutdown timeout, then flushes access logs
 func (s *server) Stop() error {
-	log.Printf("info: stop grpc server")
+	log.Printf("info: stop grpc server, wait in-flight streams up to %s", s.opt.shutdownTimeout)
 
-	s.xdsSvr.Stop()
-	s.alsSvr.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), s.opt.shutdownTimeout)
+	defer cancel()
 
+	wg := new(sync.WaitGroup)
+	wg.Add(2)
+	go gracefulStop(ctx, wg, "xds", s.xdsSvr)
+	go gracefulStop(ctx, wg, "als", s.alsSvr)
+	if s.adminSvr != nil {
+		if err := s.adminSvr.Shutdown(ctx); err != nil {
+			log.Printf("warn: admin server shutdown: %s", err.Error())
+			s.adminSvr.Close()
+		}
+	}
+	wg.Wait()
+
+	if err := s.acclog.Flush(); err != nil {
+		log.Printf("error: access log flush error: %s", err.Error())
+		return err
+	}
 	return nil
 }
 
+func gracefulStop(ctx context.Context, wg *sync.WaitGroup, name string, svr *grpc.Server) {
+	defer wg.Done()
+
+	done := make(chan struct{})
+	go func() {
+		svr.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Printf("info: %s server stopped", name)
+	case <-ctx.Done():
+		log.Printf("warn: %s server graceful stop timeout, close remaining streams", name)
+		svr.Stop()
+		<-done
+	}
+}
+
 func NewServer(ctx context.Context, cache cachev3.Cache, funcs ...serverOptFunc) *server {
 	opt := new(serverOpt)
 	for _, fn := range funcs {
@@ -153,17 +331,45 @@ func NewServer(ctx context.Context, cache cachev3.Cache, funcs ...serverOptFunc)
 	}
 	initOpt(opt)
 
-	xdsSvr := grpc.NewServer(
+	grpcOpts := []grpc.ServerOption{
 		grpc.MaxConcurrentStreams(opt.maxConcurrentStreams),
-	)
-	alsSvr := grpc.NewServer(
-		grpc.MaxConcurrentStreams(opt.maxConcurrentStreams),
-	)
+	}
+
+	var reloader *tlsReloader
+	if opt.tlsCertFile != "" && opt.tlsKeyFile != "" {
+		watchOpt := defaultFileWatchOpt()
+		if opt.watch != nil {
+			watchOpt = opt.watch.watcher.opt // --watch-polling etc. are shared
+			watchOpt.observeLost = nil       // file_watch_lost is the state of config files
+		}
+		reloader = newTLSReloader(opt.tlsCertFile, opt.tlsKeyFile, opt.tlsClientCAFile, watchOpt)
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(reloader.Config())))
+	}
+
+	registry := newProxyRegistry(opt.watch, opt.nackThreshold)
+
+	var adminSvr *http.Server
+	if opt.adminListenAddr != "" {
+		adminSvr = &http.Server{
+			Handler:           newAdminHandler(registry, opt.watch, opt.adminWrite),
+			ReadHeaderTimeout: defaultAdminReadTimeout,
+		}
+	}
+
+	acclog := newAccessLogWriter(opt.accessLogOutput, defaultAccessLogWriteBufferSize)
+
+	xdsSvr := grpc.NewServer(grpcOpts...)
+	alsSvr := grpc.NewServer(grpcOpts...)
 	return &server{
+		ctx:        ctx,
 		opt:        opt,
+		tls:        reloader,
 		xdsSvr:     xdsSvr,
 		alsSvr:     alsSvr,
-		xdsHandler: serverv3.NewServer(ctx, cache, nil),
-		alsHandler: newAccesslogServiceHandler(newLoggerAccessLog()),
+		adminSvr:   adminSvr,
+		xdsHandler: serverv3.NewServer(ctx, cache, registry),
+		alsHandler: newAccesslogServiceHandler(newLoggerAccessLog(acclog)),
+		acclog:     acclog,
+		registry:   registry,
 	}
 }
@@ -0,0 +1,160 @@
+package xds
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	FileStatusOk    string = "OK"
+	FileStatusError string = "ERROR"
+)
+
+// SnapshotState is the resources of a node group, secrets are listed by name only
+type SnapshotState struct {
+	NodeGroup string            `json:"node_group"`
+	Version   string            `json:"version"`
+	Versions  ResourceVersions  `json:"versions"`
+	Clusters  []json.RawMessage `json:"clusters"`
+	Endpoints []json.RawMessage `json:"endpoints"`
+	Routes    []json.RawMessage `json:"routes"`
+	Listeners []json.RawMessage `json:"listeners"`
+	Secrets   []string          `json:"secrets"`
+	Runtime   json.RawMessage   `json:"runtime,omitempty"`
+}
+
+type ResourceVersions struct {
+	Clusters  string `json:"clusters"`
+	Endpoints string `json:"endpoints"`
+	Routes    string `json:"routes"`
+	Listeners string `json:"listeners"`
+	Secrets   string `json:"secrets"`
+	Runtime   string `json:"runtime"`
+}
+
+// ConfigState is the parsed config files of a node group and the last reload result of each file
+type ConfigState struct {
+	NodeGroup string                 `json:"node_group"`
+	CDS       []CDSConfig            `json:"cds"`
+	EDS       []EDSConfig            `json:"eds"`
+	RDS       []RDSConfig            `json:"rds"`
+	LDS       *LDSConfig             `json:"lds"`
+	SDS       []SDSConfig            `json:"sds"`
+	Runtime   map[string]interface{} `json:"runtime"`
+	Files     []*FileStatus          `json:"files"`
+}
+
+// FileStatus is the last reload result of a config file, LastError is kept after recovery
+type FileStatus struct {
+	Type      string    `json:"type"`
+	File      string    `json:"file"`
+	Status    string    `json:"status"`
+	LoadedAt  time.Time `json:"loaded_at"`
+	LastError string    `json:"last_error"`
+	ErrorAt   time.Time `json:"error_at"`
+}
+
+// nodeGroupConfig is the parsed config files of the current resources
+type nodeGroupConfig struct {
+	cds     []CDSConfig
+	eds     []EDSConfig
+	rds     []RDSConfig
+	lds     *LDSConfig
+	sds     []SDSConfig
+	runtime RTDSConfig
+}
+
+func newSnapshotState(name string, s *resourceState) (*SnapshotState, error) {
+	state := &SnapshotState{
+		NodeGroup: name,
+		Version:   s.version(),
+		Versions: ResourceVersions{
+			Clusters:  s.clustersVersion,
+			Endpoints: s.endpointsVersion,
+			Routes:    s.routeVersion,
+			Listeners: s.listenerVersion,
+			Secrets:   s.secretsVersion,
+			Runtime:   s.runtimeVersion,
+		},
+		Clusters:  make([]json.RawMessage, 0, len(s.clusters)),
+		Endpoints: make([]json.RawMessage, 0, len(s.endpoints)),
+		Routes:    make([]json.RawMessage, 0, 1),
+		Listeners: make([]json.RawMessage, 0, 1),
+		Secrets:   make([]string, 0, len(s.secrets)),
+	}
+
+	for _, c := range s.clusters {
+		data, err := marshalProtoJSON(c)
+		if err != nil {
+			return nil, err
+		}
+		state.Clusters = append(state.Clusters, data)
+	}
+	for _, e := range s.endpoints {
+		data, err := marshalProtoJSON(e)
+		if err != nil {
+			return nil, err
+		}
+		state.Endpoints = append(state.Endpoints, data)
+	}
+	if s.route != nil {
+		data, err := marshalProtoJSON(s.route)
+		if err != nil {
+			return nil, err
+		}
+		state.Routes = append(state.Routes, data)
+	}
+	if s.listener != nil {
+		data, err := marshalProtoJSON(s.listener)
+		if err != nil {
+			return nil, err
+		}
+		state.Listeners = append(state.Listeners, data)
+	}
+	for _, secret := range s.secrets {
+		// private keys must not be exposed
+		state.Secrets = append(state.Secrets, secret.GetName())
+	}
+	if s.runtime != nil {
+		data, err := marshalProtoJSON(s.runtime)
+		if err != nil {
+			return nil, err
+		}
+		state.Runtime = data
+	}
+	return state, nil
+}
+
+func marshalProtoJSON(m proto.Message) (json.RawMessage, error) {
+	return protojson.Marshal(m)
+}
+
+// jsonValue converts map[interface{}]interface{} decoded by yaml.v2 to be json encodable
+func jsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, e := range value {
+			m[k] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(value))
+		for i, e := range value {
+			list[i] = jsonValue(e)
+		}
+		return list
+	default:
+		return value
+	}
+}
@@ -0,0 +1,44 @@
+package xds
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestConfigStateKeys checks that /config uses the same keys as the yaml files
+func TestConfigStateKeys(t *testing.T) {
+	w := NewWatchFile(context.Background())
+	cds, err := w.loadCds(NodeGroupCdsFileName)
+	if err != nil {
+		t.Fatalf("load: %s", err.Error())
+	}
+	eds, err := w.loadEds(NodeGroupEdsFileName)
+	if err != nil {
+		t.Fatalf("load: %s", err.Error())
+	}
+	rds, err := w.loadRds(NodeGroupRdsFileName)
+	if err != nil {
+		t.Fatalf("load: %s", err.Error())
+	}
+	lds, err := w.loadLds(NodeGroupLdsFileName)
+	if err != nil {
+		t.Fatalf("load: %s", err.Error())
+	}
+
+	data, err := json.Marshal(&ConfigState{CDS: cds, EDS: eds, RDS: rds, LDS: &lds})
+	if err != nil {
+		t.Fatalf("marshal: %s", err.Error())
+	}
+	for _, key := range []string{`"lb-policy":`, `"health-check":`, `"balancing-policy":`, `"instance-name":`, `"vhost":`, `"string_match":`, `"use-remote-addr":`, `"log-id":`} {
+		if strings.Contains(string(data), key) != true {
+			t.Errorf("key %s not found: %s", key, data)
+		}
+	}
+	for _, key := range []string{`"ClusterName":`, `"LbPolicy":`, `"VHostName":`, `"Listen":`} {
+		if strings.Contains(string(data), key) {
+			t.Errorf("go field name %s must not be used: %s", key, data)
+		}
+	}
+}
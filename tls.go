@@ -0,0 +1,120 @@
+package xds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sync"
+)
+
+// tlsReloader serves the latest certificate and client CA,
+// files are reloaded on change and the previous material is kept on error
+type tlsReloader struct {
+	mutex        *sync.RWMutex
+	certFile     string
+	keyFile      string
+	clientCAFile string
+	cert         *tls.Certificate
+	clientCAs    *x509.CertPool
+	watcher      *fileWatcher
+	loaded       map[string]string // checksums of files hashed before the last load
+}
+
+func (t *tlsReloader) files() []string {
+	files := []string{t.certFile, t.keyFile}
+	if t.clientCAFile != "" {
+		files = append(files, t.clientCAFile)
+	}
+	for i, file := range files {
+		if abs, err := filepath.Abs(file); err == nil {
+			files[i] = abs
+		}
+	}
+	return files
+}
+
+func (t *tlsReloader) load() error {
+	loaded := pathChecksums(t.files())
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if t.clientCAFile != "" {
+		data, err := ioutil.ReadFile(t.clientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if clientCAs.AppendCertsFromPEM(data) != true {
+			return fmt.Errorf("no certificate found in %s", t.clientCAFile)
+		}
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.cert = &cert
+	t.clientCAs = clientCAs
+	t.loaded = loaded
+	return nil
+}
+
+func (t *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{*t.cert},
+		NextProtos:   []string{"h2"}, // grpc
+	}
+	if t.clientCAs != nil {
+		// mTLS
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		config.ClientCAs = t.clientCAs
+	}
+	return config, nil
+}
+
+func (t *tlsReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: t.getConfigForClient,
+	}
+}
+
+// Watch starts watching the certificate, key and client CA files in the same way as the config files
+func (t *tlsReloader) Watch(ctx context.Context) error {
+	t.mutex.RLock()
+	loaded := t.loaded
+	t.mutex.RUnlock()
+
+	t.watcher.seed(loaded)
+	t.watcher.start(ctx)
+	return nil
+}
+
+func (t *tlsReloader) changeFiles(names ...string) {
+	if err := t.load(); err != nil {
+		log.Printf("warn: reload tls failed, keep current certificate: %s", err.Error())
+		return
+	}
+	log.Printf("info: reload tls succeed")
+}
+
+func newTLSReloader(certFile, keyFile, clientCAFile string, watchOpt fileWatchOpt) *tlsReloader {
+	t := &tlsReloader{
+		mutex:        new(sync.RWMutex),
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	t.watcher = newFileWatcher("tls", watchOpt, t.files, t.changeFiles)
+	return t
+}
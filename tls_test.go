@@ -0,0 +1,110 @@
+package xds
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a self-signed certificate and its key in PEM
+func testCertificate(t *testing.T, commonName string) ([]byte, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %s", err.Error())
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(1 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %s", err.Error())
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %s", err.Error())
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+func TestTLSReloaderWatch(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	// atomic rename save, the watched inode is replaced
+	save := func(path string, data []byte) {
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			t.Fatalf("write: %s", err.Error())
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			t.Fatalf("rename: %s", err.Error())
+		}
+	}
+	commonName := func(r *tlsReloader) string {
+		config, err := r.getConfigForClient(nil)
+		if err != nil {
+			t.Fatalf("config: %s", err.Error())
+		}
+		cert, err := x509.ParseCertificate(config.Certificates[0].Certificate[0])
+		if err != nil {
+			t.Fatalf("parse: %s", err.Error())
+		}
+		return cert.Subject.CommonName
+	}
+
+	cert, key := testCertificate(t, "v1")
+	save(certFile, cert)
+	save(keyFile, key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watchOpt := defaultFileWatchOpt()
+	watchOpt.debounce = 50 * time.Millisecond
+	r := newTLSReloader(certFile, keyFile, "", watchOpt)
+	if err := r.load(); err != nil {
+		t.Fatalf("load: %s", err.Error())
+	}
+	if err := r.Watch(ctx); err != nil {
+		t.Fatalf("watch: %s", err.Error())
+	}
+
+	// cert and key saved one after another are loaded together after the debounce
+	cert, key = testCertificate(t, "v2")
+	save(certFile, cert)
+	save(keyFile, key)
+	testEventually(t, func() bool {
+		return commonName(r) == "v2"
+	})
+
+	// a broken certificate keeps the current one, the next valid one is still noticed
+	save(certFile, []byte("broken"))
+	time.Sleep(200 * time.Millisecond)
+	if cn := commonName(r); cn != "v2" {
+		t.Errorf("broken certificate must keep current: %s", cn)
+	}
+	cert, key = testCertificate(t, "v3")
+	save(keyFile, key)
+	save(certFile, cert)
+	testEventually(t, func() bool {
+		return commonName(r) == "v3"
+	})
+	if st := r.watcher.Status(); st.Status != WatchStatusOk {
+		t.Errorf("expect %s actual %+v", WatchStatusOk, st)
+	}
+}
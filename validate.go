@@ -0,0 +1,276 @@
+package xds
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ValidationError is a problem of a config file found by Validate,
+// Path is the yaml path of the item in File(e.g. [0].cluster[1].target[0].name) if known
+type ValidationError struct {
+	NodeGroup string `json:"node_group"`
+	File      string `json:"file"`
+	Path      string `json:"path,omitempty"`
+	Message   string `json:"message"`
+}
+
+// Location is File or File:Path
+func (e *ValidationError) Location() string {
+	if e.Path == "" {
+		return e.File
+	}
+	return e.File + ":" + e.Path
+}
+
+func (e *ValidationError) Error() string {
+	return e.Location() + ": " + e.Message
+}
+
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate loads the config files of each node group the same way as the server,
+// builds the snapshots and checks their consistency without publishing them
+func (w *WatchFile) Validate() ValidationErrors {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	errs := make(ValidationErrors, 0)
+	for _, g := range w.groups {
+		_, groupErrs := w.buildGroup(g)
+		errs = append(errs, groupErrs...)
+	}
+	return errs
+}
+
+// buildGroup generates the resources of the files of g without touching the published ones
+func (w *WatchFile) buildGroup(g *nodeGroup) (*resource, ValidationErrors) {
+	r, _, errs := w.buildCandidate(g)
+	return r, errs
+}
+
+// buildCandidate loads all files of g and generates the resources and the parsed config,
+// the candidate is consistent and can be published as is if no errors are returned
+func (w *WatchFile) buildCandidate(g *nodeGroup) (*resource, nodeGroupConfig, ValidationErrors) {
+	errs := make(ValidationErrors, 0)
+	addErr := func(file string, msg string) {
+		errs = append(errs, &ValidationError{NodeGroup: g.name, File: file, Message: msg})
+	}
+
+	r := newResource()
+	cdsConfig, cdsSources, err := w.loadCdsSources(g.cdsYaml)
+	if err != nil {
+		addErr(g.cdsYaml, err.Error())
+	} else if version, clusters, err := w.cds.create(cdsConfig); err != nil {
+		addErr(g.cdsYaml, err.Error())
+	} else {
+		r.updateCluster(version, clusters)
+	}
+
+	edsConfig, edsSources, err := w.loadEdsSources(g.edsYaml)
+	if err != nil {
+		addErr(g.edsYaml, err.Error())
+	} else if version, endpoints, err := w.eds.create(edsConfig); err != nil {
+		addErr(g.edsYaml, err.Error())
+	} else {
+		r.updateEndpoint(version, endpoints)
+	}
+
+	rdsConfig, rdsSources, err := w.loadRdsSources(g.rdsYaml)
+	if err != nil {
+		addErr(g.rdsYaml, err.Error())
+	} else if version, route, err := w.rds.create(rdsConfig); err != nil {
+		addErr(g.rdsYaml, err.Error())
+	} else {
+		r.updateRoute(version, route)
+	}
+
+	ldsConfig, err := w.loadLds(g.ldsYaml)
+	if err != nil {
+		addErr(g.ldsYaml, err.Error())
+	} else if version, listener, err := w.lds.create(ldsConfig); err != nil {
+		addErr(g.ldsYaml, err.Error())
+	} else {
+		r.updateListener(version, listener)
+	}
+
+	sdsConfig, err := w.loadSds(g.sdsYaml)
+	if err != nil {
+		addErr(g.sdsYaml, err.Error())
+	} else if version, secrets, err := w.sds.create(sdsConfig); err != nil {
+		addErr(g.sdsYaml, err.Error())
+	} else {
+		r.updateSecret(version, secrets)
+	}
+
+	runtimeConfig, err := w.loadRuntime(g.runtimeYaml)
+	if err != nil {
+		addErr(g.runtimeYaml, err.Error())
+	} else if runtimeConfig != nil {
+		if version, runtime, err := w.rtds.create(runtimeConfig); err != nil {
+			addErr(g.runtimeYaml, err.Error())
+		} else {
+			r.updateRuntime(version, runtime)
+		}
+	}
+
+	if 0 < len(errs) {
+		return nil, nodeGroupConfig{}, errs // snapshot needs all files
+	}
+
+	for _, name := range duplicateNames(cdsClusterNames(cdsConfig)) {
+		addErr(g.cdsYaml, "duplicate cluster name '"+name+"'")
+	}
+	for _, name := range duplicateNames(edsClusterNames(edsConfig)) {
+		addErr(g.edsYaml, "duplicate endpoints name '"+name+"'")
+	}
+	for _, name := range duplicateNames(rdsVHostNames(rdsConfig)) {
+		addErr(g.rdsYaml, "duplicate vhost name '"+name+"'")
+	}
+	errs = append(errs, referenceErrors(g, parsedConfigs{
+		cds:        cdsConfig,
+		cdsSources: cdsSources,
+		eds:        edsConfig,
+		edsSources: edsSources,
+		rds:        rdsConfig,
+		rdsSources: rdsSources,
+	})...)
+	if 0 < len(errs) {
+		return nil, nodeGroupConfig{}, errs
+	}
+
+	// same consistency check as publishing
+	if _, _, err := r.Snapshot(); err != nil {
+		addErr(filepath.Dir(g.cdsYaml), err.Error())
+		return nil, nodeGroupConfig{}, errs
+	}
+
+	config := nodeGroupConfig{
+		cds:     cdsConfig,
+		eds:     edsConfig,
+		rds:     rdsConfig,
+		lds:     &ldsConfig,
+		sds:     sdsConfig,
+		runtime: runtimeConfig,
+	}
+	return r, config, errs
+}
+
+// configSource is the file and the index in it of a parsed config item
+type configSource struct {
+	file  string
+	index int
+}
+
+func (s configSource) path(suffix string) string {
+	return fmt.Sprintf("[%d]%s", s.index, suffix)
+}
+
+// parsedConfigs is the parsed cds, eds and rds config of a node group and the source of each item
+type parsedConfigs struct {
+	cds        []CDSConfig
+	cdsSources []configSource
+	eds        []EDSConfig
+	edsSources []configSource
+	rds        []RDSConfig
+	rdsSources []configSource
+}
+
+// referenceErrors is the semantic validation across the files of g by the names written in yaml:
+// clusters without endpoints and endpoints not used by any cluster(both make the snapshot inconsistent),
+// route targets not defined in cds and routes whose target weights sum to zero(envoy rejects them)
+func referenceErrors(g *nodeGroup, c parsedConfigs) ValidationErrors {
+	clusterNames := make(map[string]struct{}, len(c.cds))
+	for _, name := range cdsClusterNames(c.cds) {
+		clusterNames[name] = struct{}{}
+	}
+	endpointNames := make(map[string]struct{}, len(c.eds))
+	for _, name := range edsClusterNames(c.eds) {
+		endpointNames[name] = struct{}{}
+	}
+
+	errs := make(ValidationErrors, 0)
+	addErr := func(src configSource, path string, msg string) {
+		errs = append(errs, &ValidationError{
+			NodeGroup: g.name,
+			File:      src.file,
+			Path:      src.path(path),
+			Message:   msg,
+		})
+	}
+	for i, config := range c.cds {
+		if _, ok := endpointNames[config.ClusterName]; ok != true {
+			addErr(c.cdsSources[i], ".name", "cluster '"+config.ClusterName+"' has no endpoints named '"+config.ClusterName+"' in "+g.edsYaml)
+		}
+	}
+	for i, config := range c.eds {
+		if _, ok := clusterNames[config.ClusterName]; ok != true {
+			addErr(c.edsSources[i], ".name", "endpoints '"+config.ClusterName+"' are not used by any cluster in "+g.cdsYaml)
+		}
+	}
+	for i, config := range c.rds {
+		for j, route := range config.Cluster {
+			totalWeight := uint32(0)
+			for k, target := range route.Target {
+				totalWeight += target.Weight
+				if _, ok := clusterNames[target.ClusterName]; ok != true {
+					addErr(c.rdsSources[i], fmt.Sprintf(".cluster[%d].target[%d].name", j, k),
+						"cluster '"+target.ClusterName+"' of vhost '"+config.VHostName+"' route '"+route.Prefix+"' is not defined in "+g.cdsYaml)
+				}
+			}
+			if 0 < len(route.Target) && totalWeight == 0 {
+				addErr(c.rdsSources[i], fmt.Sprintf(".cluster[%d].target", j),
+					"weights of vhost '"+config.VHostName+"' route '"+route.Prefix+"' sum to zero")
+			}
+		}
+	}
+	return errs
+}
+
+func cdsClusterNames(configs []CDSConfig) []string {
+	names := make([]string, len(configs))
+	for i, c := range configs {
+		names[i] = c.ClusterName
+	}
+	return names
+}
+
+func edsClusterNames(configs []EDSConfig) []string {
+	names := make([]string, len(configs))
+	for i, c := range configs {
+		names[i] = c.ClusterName
+	}
+	return names
+}
+
+func rdsVHostNames(configs []RDSConfig) []string {
+	names := make([]string, len(configs))
+	for i, c := range configs {
+		names[i] = c.VHostName
+	}
+	return names
+}
+
+func duplicateNames(names []string) []string {
+	seen := make(map[string]int, len(names))
+	for _, name := range names {
+		seen[name] += 1
+	}
+	dups := make([]string, 0)
+	for name, n := range seen {
+		if 1 < n {
+			dups = append(dups, name)
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
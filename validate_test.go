@@ -0,0 +1,112 @@
+package xds
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateReferences(t *testing.T) {
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %s", name, err.Error())
+		}
+		return string(data)
+	}
+	cds, eds, rds, lds := read(NodeGroupCdsFileName), read(NodeGroupEdsFileName), read(NodeGroupRdsFileName), read(NodeGroupLdsFileName)
+	vhostImage := rds[strings.Index(rds, "- vhost: \"vhost-image\""):]
+
+	type expectError struct {
+		file    string
+		path    string
+		message string
+	}
+	tests := []struct {
+		name   string
+		files  map[string]string
+		expect []expectError
+	}{
+		{
+			name:   "valid",
+			files:  map[string]string{},
+			expect: []expectError{},
+		},
+		{
+			name: "dangling route target",
+			files: map[string]string{
+				NodeGroupRdsFileName: strings.Replace(rds, "{name: web-api-new, weight: 100}", "{name: web-api-next, weight: 100}", 1),
+			},
+			expect: []expectError{
+				{NodeGroupRdsFileName, "[0].cluster[1].target[0].name", "cluster 'web-api-next' of vhost 'vhost-api' route '/' is not defined in"},
+			},
+		},
+		{
+			name: "zero weights",
+			files: map[string]string{
+				NodeGroupRdsFileName: strings.Replace(rds, "weight: 100\n  action:\n    timeout: 100", "weight: 0\n  action:\n    timeout: 100", 1),
+			},
+			expect: []expectError{
+				{NodeGroupRdsFileName, "[1].cluster[0].target", "weights of vhost 'vhost-image' route '/' sum to zero"},
+			},
+		},
+		{
+			name: "cluster without endpoints and orphaned endpoints",
+			files: map[string]string{
+				NodeGroupEdsFileName: strings.Replace(eds, "- name: web-image", "- name: web-images", 1),
+			},
+			expect: []expectError{
+				{NodeGroupCdsFileName, "[2].name", "cluster 'web-image' has no endpoints named 'web-image' in"},
+				{NodeGroupEdsFileName, "[2].name", "endpoints 'web-images' are not used by any cluster in"},
+			},
+		},
+		{
+			name: "conf.d",
+			files: map[string]string{
+				NodeGroupRdsFileName: "",
+				"rds.d/a.yaml":       rds[:strings.Index(rds, "- vhost: \"vhost-image\"")],
+				"rds.d/b.yaml":       strings.Replace(vhostImage, "name: web-image", "name: web-video", 1),
+			},
+			expect: []expectError{
+				{filepath.Join("rds.d", "b.yaml"), "[0].cluster[0].target[0].name", "cluster 'web-video' of vhost 'vhost-image' route '/' is not defined in"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(tc *testing.T) {
+			dir := tc.TempDir()
+			files := map[string]string{
+				NodeGroupCdsFileName: cds,
+				NodeGroupEdsFileName: eds,
+				NodeGroupRdsFileName: rds,
+				NodeGroupLdsFileName: lds,
+			}
+			for name, content := range tt.files {
+				files[name] = content
+			}
+			testWriteFiles(tc, dir, files)
+
+			rdsPath := filepath.Join(dir, NodeGroupRdsFileName)
+			if _, ok := tt.files["rds.d/a.yaml"]; ok {
+				rdsPath = filepath.Join(dir, "rds.d")
+			}
+			w := NewWatchFile(context.Background(),
+				WatchCdsConfigFile(filepath.Join(dir, NodeGroupCdsFileName)),
+				WatchEdsConfigFile(filepath.Join(dir, NodeGroupEdsFileName)),
+				WatchRdsConfigFile(rdsPath),
+				WatchLdsConfigFile(filepath.Join(dir, NodeGroupLdsFileName)),
+			)
+			errs := w.Validate()
+			if len(errs) != len(tt.expect) {
+				tc.Fatalf("expect %d error(s) actual %v", len(tt.expect), errs)
+			}
+			for i, e := range tt.expect {
+				if errs[i].File != filepath.Join(dir, e.file) || errs[i].Path != e.path || strings.HasPrefix(errs[i].Message, e.message) != true {
+					tc.Errorf("error #%d: expect %s:%s %s actual %s", i, e.file, e.path, e.message, errs[i].Error())
+				}
+			}
+		})
+	}
+}
@@ -2,25 +2,44 @@ package xds
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/fsnotify/fsnotify"
 	"gopkg.in/go-playground/validator.v9"
 	"gopkg.in/yaml.v2"
 
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 )
 
 type watchOptFunc func(*watchOpt)
 
+const (
+	defaultAdsWarmingInterval time.Duration = 1 * time.Second
+)
+
 type watchOpt struct {
-	cdsYaml string
-	edsYaml string
-	rdsYaml string
-	ldsYaml string
+	cdsYaml            string
+	edsYaml            string
+	rdsYaml            string
+	ldsYaml            string
+	sdsYaml            string
+	runtimeYaml        string
+	nodeGroups         []NodeGroup
+	ads                bool
+	adsWarmingInterval time.Duration
+	delta              bool
+	stateDir           string
+	historySize        int
+	watchPolling       bool
+	watchPollInterval  time.Duration
+	watchDebounce      time.Duration
 }
 
 func WatchCdsConfigFile(path string) watchOptFunc {
@@ -47,174 +66,195 @@ func WatchLdsConfigFile(path string) watchOptFunc {
 	}
 }
 
-type WatchFile struct {
-	ctx      context.Context
-	nodeId   string
-	opt      *watchOpt
-	cache    cachev3.SnapshotCache
-	cds      *clusterDiscoveryService
-	eds      *endpointDiscoveryService
-	rds      *routeDiscoveryService
-	lds      *listenerDiscoveryService
-	resource *resource
+// WatchSdsConfigFile is optional, secrets are not served if empty
+func WatchSdsConfigFile(path string) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.sdsYaml = path
+	}
 }
 
-func (w *WatchFile) Cache() cachev3.Cache {
-	return w.cache
+// WatchRuntimeConfigFile is optional, runtime layer is not served if empty
+func WatchRuntimeConfigFile(path string) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.runtimeYaml = path
+	}
 }
 
-func (w *WatchFile) Watch(ctx context.Context) error {
-	watcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return err
-	}
-	if err := watcher.Add(w.opt.cdsYaml); err != nil {
-		return err
-	}
-	if err := watcher.Add(w.opt.edsYaml); err != nil {
-		return err
-	}
-	if err := watcher.Add(w.opt.ldsYaml); err != nil {
-		return err
-	}
-	if err := watcher.Add(w.opt.rdsYaml); err != nil {
-		return err
+// WatchNodeGroups adds groups served from the files in NodeGroup.Dir,
+// nodes that do not match any group are served from the default group
+func WatchNodeGroups(groups ...NodeGroup) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.nodeGroups = append(opt.nodeGroups, groups...)
 	}
-
-	go w.watchFileLoop(ctx, watcher)
-	return nil
 }
 
-func (w *WatchFile) watchFileLoop(ctx context.Context, watcher *fsnotify.Watcher) {
-	defer log.Printf("info: stop file watching")
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-
-		case err, ok := <-watcher.Errors:
-			if ok != true {
-				return
-			}
-			log.Printf("error: %s", err)
-
-		case evt, ok := <-watcher.Events:
-			if ok != true {
-				return
-			}
-
-			if evt.Op == fsnotify.Rename {
-				time.Sleep(100 * time.Millisecond) // wait file writes (todo retry)
-			}
-
-			if evt.Op != fsnotify.Chmod {
-				log.Printf("info: file changed: %s(%s)", evt.Name, evt.Op)
-				if equalPath(evt.Name, w.opt.cdsYaml) {
-					if err := w.changeCdsYaml(); err != nil {
-						log.Printf("warn: %s", err)
-					}
-				}
-				if equalPath(evt.Name, w.opt.edsYaml) {
-					if err := w.changeEdsYaml(); err != nil {
-						log.Printf("warn: %s", err)
-					}
-				}
-				if equalPath(evt.Name, w.opt.rdsYaml) {
-					if err := w.changeRdsYaml(); err != nil {
-						log.Printf("warn: %s", err)
-					}
-				}
-				if equalPath(evt.Name, w.opt.ldsYaml) {
-					if err := w.changeLdsYaml(); err != nil {
-						log.Printf("warn: %s", err)
-					}
-				}
-			}
-
-			// recursive watch
-			if err := watcher.Add(evt.Name); err != nil {
-				log.Printf("error: failed add watch(%s) to fsnotify: %s", evt.Name, err.Error())
-				return
-			}
-		}
+// WatchAds enables ADS mode, resources refer to the ADS stream and
+// snapshots are published in CDS/EDS -> LDS/RDS order
+func WatchAds(enable bool) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.ads = enable
 	}
 }
 
-func (w *WatchFile) changeCdsYaml() error {
-	config, err := w.loadCds()
-	if err != nil {
-		log.Printf("info: load CDS failed: %s", err)
-		return err
+func WatchAdsWarmingInterval(dur time.Duration) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.adsWarmingInterval = dur
 	}
+}
 
-	if err := w.updateCds(config); err != nil {
-		log.Printf("info: update CDS failed: %s", err)
-		return err
+// WatchDelta advertises DELTA_GRPC in the generated ConfigSource,
+// envoy then receives only the changed resources (incremental xDS)
+func WatchDelta(enable bool) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.delta = enable
 	}
-	log.Printf("info: update CDS succeed")
+}
 
-	if err := w.updateSnapshot(); err != nil {
-		return err
+// WatchStateDir persists each applied snapshot to dir,
+// a node group is served from it when its files are broken at startup (InitialLoad)
+func WatchStateDir(dir string) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.stateDir = dir
 	}
-	return nil
 }
 
-func (w *WatchFile) changeEdsYaml() error {
-	config, err := w.loadEds()
-	if err != nil {
-		log.Printf("info: load EDS failed: %s", err)
-		return err
+// WatchHistorySize is the number of applied snapshots kept for each node group
+func WatchHistorySize(size int) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.historySize = size
 	}
+}
 
-	if err := w.updateEds(config); err != nil {
-		log.Printf("info: update EDS failed: %s", err)
-		return err
+// WatchPolling finds file changes by polling their content hash instead of fsnotify,
+// for file systems without inotify(NFS etc.), polling is also the fallback while fsnotify is lost
+func WatchPolling(enable bool) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.watchPolling = enable
 	}
-	log.Printf("info: update EDS succeed")
+}
 
-	if err := w.updateSnapshot(); err != nil {
-		return err
+func WatchPollInterval(dur time.Duration) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.watchPollInterval = dur
 	}
-	return nil
 }
 
-func (w *WatchFile) changeRdsYaml() error {
-	config, err := w.loadRds()
-	if err != nil {
-		log.Printf("info: load RDS failed: %s", err)
-		return err
+// WatchDebounce waits a burst of file events(editors, ConfigMap updates) to settle before reloading
+func WatchDebounce(dur time.Duration) watchOptFunc {
+	return func(opt *watchOpt) {
+		opt.watchDebounce = dur
 	}
+}
 
-	if err := w.updateRds(config); err != nil {
-		log.Printf("info: update RDS failed: %s", err)
-		return err
+func initWatchOpt(opt *watchOpt) {
+	if opt.adsWarmingInterval < 1 {
+		opt.adsWarmingInterval = defaultAdsWarmingInterval
+	}
+	if opt.historySize < 1 {
+		opt.historySize = defaultHistorySize
+	}
+	if opt.watchPollInterval < 1 {
+		opt.watchPollInterval = defaultWatchPollInterval
 	}
-	log.Printf("info: update RDS succeed")
+	if opt.watchDebounce < 1 {
+		opt.watchDebounce = defaultWatchDebounce
+	}
+}
 
-	if err := w.updateSnapshot(); err != nil {
-		return err
+type WatchFile struct {
+	ctx     context.Context
+	mutex   *sync.Mutex
+	opt     *watchOpt
+	cache   cachev3.SnapshotCache
+	cds     *clusterDiscoveryService
+	eds     *endpointDiscoveryService
+	rds     *routeDiscoveryService
+	lds     *listenerDiscoveryService
+	sds     *secretDiscoveryService
+	rtds    *runtimeDiscoveryService
+	hash    *nodeGroupHash
+	groups  []*nodeGroup
+	watcher *fileWatcher
+	loaded  map[string]string // checksums of watchPaths hashed before InitialLoad
+}
+
+func (w *WatchFile) Cache() cachev3.Cache {
+	return w.cache
+}
+
+// NodeGroupName returns the name of node group which serves node
+func (w *WatchFile) NodeGroupName(node *corev3.Node) string {
+	return w.hash.ID(node)
+}
+
+func (w *WatchFile) findGroup(name string) (*nodeGroup, bool) {
+	for _, g := range w.groups {
+		if g.name == name {
+			return g, true
+		}
 	}
+	return nil, false
+}
+
+// Watch starts watching the config files, it falls back to polling if fsnotify is unavailable
+func (w *WatchFile) Watch(ctx context.Context) error {
+	w.mutex.Lock()
+	loaded := w.loaded
+	w.mutex.Unlock()
+
+	w.watcher.seed(loaded)
+	w.watcher.start(ctx)
 	return nil
 }
 
-func (w *WatchFile) changeLdsYaml() error {
-	config, err := w.loadLds()
-	if err != nil {
-		log.Printf("info: load LDS failed: %s", err)
-		return err
-	}
+// WatchStatus returns the state of config file watching
+func (w *WatchFile) WatchStatus() WatchStatus {
+	return w.watcher.Status()
+}
 
-	if err := w.updateLds(config); err != nil {
-		log.Printf("info: update LDS failed: %s", err)
-		return err
+// watchPaths returns the config files(or conf.d directories) and the secret files of all node groups
+func (w *WatchFile) watchPaths() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	paths := make([]string, 0)
+	seen := make(map[string]struct{})
+	for _, g := range w.groups {
+		for _, file := range g.files() {
+			if abs, err := filepath.Abs(file); err == nil {
+				file = abs
+			}
+			if _, ok := seen[file]; ok {
+				continue
+			}
+			seen[file] = struct{}{}
+			paths = append(paths, file)
+		}
 	}
-	log.Printf("info: update LDS succeed")
+	return paths
+}
 
-	if err := w.updateSnapshot(); err != nil {
-		return err
+// changeFiles reloads each node group using any of names as a single transaction,
+// changes of several files(cds.yaml and rds.yaml etc.) are applied at once
+func (w *WatchFile) changeFiles(names ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	for _, g := range w.groups {
+		types := g.changedTypes(names)
+		if len(types) < 1 {
+			continue
+		}
+		if g.pinned != 0 {
+			log.Printf("info: xds %s is pinned to history #%d, skip file change: %s", g.name, g.pinned, strings.Join(types, ","))
+			continue
+		}
+		if err := w.reloadGroup(g, types); err != nil {
+			log.Printf("warn: xds %s reload(%s) rejected, keep serving current snapshot: %s", g.name, strings.Join(types, ","), err.Error())
+			continue
+		}
+		log.Printf("info: xds %s reload(%s) succeed", g.name, strings.Join(types, ","))
 	}
-	return nil
 }
 
 func (w *WatchFile) loadYaml(file string, bind interface{}) error {
@@ -231,168 +271,668 @@ func (w *WatchFile) loadYaml(file string, bind interface{}) error {
 	return nil
 }
 
-func (w *WatchFile) loadCds() ([]CDSConfig, error) {
-	configs := make([]CDSConfig, 0)
-	if err := w.loadYaml(w.opt.cdsYaml, &configs); err != nil {
-		return []CDSConfig{}, err
+func (w *WatchFile) loadCds(path string) ([]CDSConfig, error) {
+	configs, _, err := w.loadCdsSources(path)
+	return configs, err
+}
+
+// loadCdsSources also returns the file each item is loaded from
+func (w *WatchFile) loadCdsSources(path string) ([]CDSConfig, []configSource, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return []CDSConfig{}, nil, err
 	}
 
 	v := validator.New()
-	for _, config := range configs {
-		if err := v.Struct(config); err != nil {
-			return []CDSConfig{}, err
+	configs := make([]CDSConfig, 0)
+	sources := make([]configSource, 0)
+	defined := make(map[string]string)
+	for _, file := range files {
+		items := make([]CDSConfig, 0)
+		if err := w.loadYaml(file, &items); err != nil {
+			return []CDSConfig{}, nil, fileError(path, file, err)
 		}
+		for i, config := range items {
+			if err := v.Struct(config); err != nil {
+				return []CDSConfig{}, nil, fileError(path, file, fmt.Errorf("item #%d(%s): %s", i, config.ClusterName, err.Error()))
+			}
+			if src, ok := defined[config.ClusterName]; ok && src != file {
+				return []CDSConfig{}, nil, duplicateError("cluster", config.ClusterName, src, file)
+			}
+			defined[config.ClusterName] = file
+			sources = append(sources, configSource{file: file, index: i})
+		}
+		configs = append(configs, items...)
 	}
-	return configs, nil
+	return configs, sources, nil
 }
 
-func (w *WatchFile) loadEds() ([]EDSConfig, error) {
-	configs := make([]EDSConfig, 0)
-	if err := w.loadYaml(w.opt.edsYaml, &configs); err != nil {
-		return []EDSConfig{}, err
+func (w *WatchFile) loadEds(path string) ([]EDSConfig, error) {
+	configs, _, err := w.loadEdsSources(path)
+	return configs, err
+}
+
+// loadEdsSources also returns the file each item is loaded from
+func (w *WatchFile) loadEdsSources(path string) ([]EDSConfig, []configSource, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return []EDSConfig{}, nil, err
 	}
 
 	v := validator.New()
-	for _, config := range configs {
-		if err := v.Struct(config); err != nil {
-			return []EDSConfig{}, err
+	configs := make([]EDSConfig, 0)
+	sources := make([]configSource, 0)
+	defined := make(map[string]string)
+	for _, file := range files {
+		items := make([]EDSConfig, 0)
+		if err := w.loadYaml(file, &items); err != nil {
+			return []EDSConfig{}, nil, fileError(path, file, err)
 		}
+		for i, config := range items {
+			if err := v.Struct(config); err != nil {
+				return []EDSConfig{}, nil, fileError(path, file, fmt.Errorf("item #%d(%s): %s", i, config.ClusterName, err.Error()))
+			}
+			if src, ok := defined[config.ClusterName]; ok && src != file {
+				return []EDSConfig{}, nil, duplicateError("endpoints", config.ClusterName, src, file)
+			}
+			defined[config.ClusterName] = file
+			sources = append(sources, configSource{file: file, index: i})
+		}
+		configs = append(configs, items...)
 	}
-	return configs, nil
+	return configs, sources, nil
 }
 
-func (w *WatchFile) loadRds() ([]RDSConfig, error) {
-	configs := make([]RDSConfig, 0)
-	if err := w.loadYaml(w.opt.rdsYaml, &configs); err != nil {
-		return []RDSConfig{}, err
+func (w *WatchFile) loadRds(path string) ([]RDSConfig, error) {
+	configs, _, err := w.loadRdsSources(path)
+	return configs, err
+}
+
+// loadRdsSources also returns the file each item is loaded from
+func (w *WatchFile) loadRdsSources(path string) ([]RDSConfig, []configSource, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return []RDSConfig{}, nil, err
 	}
 
 	v := validator.New()
-	for _, config := range configs {
-		if err := v.Struct(config); err != nil {
-			return []RDSConfig{}, err
+	configs := make([]RDSConfig, 0)
+	sources := make([]configSource, 0)
+	defined := make(map[string]string)
+	for _, file := range files {
+		items := make([]RDSConfig, 0)
+		if err := w.loadYaml(file, &items); err != nil {
+			return []RDSConfig{}, nil, fileError(path, file, err)
+		}
+		for i, config := range items {
+			if err := v.Struct(config); err != nil {
+				return []RDSConfig{}, nil, fileError(path, file, fmt.Errorf("item #%d(%s): %s", i, config.VHostName, err.Error()))
+			}
+			if src, ok := defined[config.VHostName]; ok && src != file {
+				return []RDSConfig{}, nil, duplicateError("vhost", config.VHostName, src, file)
+			}
+			defined[config.VHostName] = file
+			sources = append(sources, configSource{file: file, index: i})
 		}
+		configs = append(configs, items...)
 	}
-	return configs, nil
+	return configs, sources, nil
 }
 
-func (w *WatchFile) loadLds() (LDSConfig, error) {
-	config := LDSConfig{}
-	if err := w.loadYaml(w.opt.ldsYaml, &config); err != nil {
+// loadLds accepts a conf.d directory with exactly one file, a node group serves a single listener
+func (w *WatchFile) loadLds(path string) (LDSConfig, error) {
+	files, err := configFiles(path)
+	if err != nil {
 		return LDSConfig{}, err
 	}
+	if len(files) != 1 {
+		return LDSConfig{}, fmt.Errorf("%s must contain exactly one yaml file: %d found", path, len(files))
+	}
+	file := files[0]
+
+	config := LDSConfig{}
+	if err := w.loadYaml(file, &config); err != nil {
+		return LDSConfig{}, fileError(path, file, err)
+	}
 
 	v := validator.New()
 	if err := v.Struct(config); err != nil {
-		return LDSConfig{}, err
+		return LDSConfig{}, fileError(path, file, err)
 	}
 	return config, nil
 }
 
-func (w *WatchFile) updateCds(config []CDSConfig) error {
-	version, clusters, err := w.cds.create(config)
+func (w *WatchFile) loadSds(path string) ([]SDSConfig, error) {
+	configs := make([]SDSConfig, 0)
+	if path == "" {
+		return configs, nil
+	}
+	files, err := configFiles(path)
 	if err != nil {
-		return err
+		return []SDSConfig{}, err
 	}
-	w.resource.updateCluster(version, clusters)
-	return nil
+
+	v := validator.New()
+	sources := make(map[string]string)
+	for _, file := range files {
+		items := make([]SDSConfig, 0)
+		if err := w.loadYaml(file, &items); err != nil {
+			return []SDSConfig{}, fileError(path, file, err)
+		}
+		baseDir := filepath.Dir(file)
+		for i, config := range items {
+			if err := v.Struct(config); err != nil {
+				return []SDSConfig{}, fileError(path, file, fmt.Errorf("item #%d(%s): %s", i, config.SecretName, err.Error()))
+			}
+			if src, ok := sources[config.SecretName]; ok && src != file {
+				return []SDSConfig{}, duplicateError("secret", config.SecretName, src, file)
+			}
+			sources[config.SecretName] = file
+			// relative path from sds.yaml
+			items[i].CertFile = joinRelPath(baseDir, config.CertFile)
+			items[i].KeyFile = joinRelPath(baseDir, config.KeyFile)
+			items[i].CAFile = joinRelPath(baseDir, config.CAFile)
+		}
+		configs = append(configs, items...)
+	}
+	return configs, nil
 }
 
-func (w *WatchFile) updateEds(config []EDSConfig) error {
-	version, endpoints, err := w.eds.create(config)
+func (w *WatchFile) loadRuntime(path string) (RTDSConfig, error) {
+	if path == "" {
+		return nil, nil
+	}
+	files, err := configFiles(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	w.resource.updateEndpoint(version, endpoints)
-	return nil
+
+	config := RTDSConfig{}
+	sources := make(map[string]string)
+	for _, file := range files {
+		layer := RTDSConfig{}
+		if err := w.loadYaml(file, &layer); err != nil {
+			return nil, fileError(path, file, err)
+		}
+		for key, value := range layer {
+			if src, ok := sources[key]; ok {
+				return nil, duplicateError("runtime key", key, src, file)
+			}
+			sources[key] = file
+			config[key] = value
+		}
+	}
+	return config, nil
+}
+
+func (w *WatchFile) updateSnapshot(g *nodeGroup) error {
+	return w.applySnapshot(g, HistorySourceFiles, fileChecksums(g.files()))
 }
 
-func (w *WatchFile) updateRds(config []RDSConfig) error {
-	version, route, err := w.rds.create(config)
+// applySnapshot pushes the current resources and records them to history
+func (w *WatchFile) applySnapshot(g *nodeGroup, source string, checksums map[string]string) error {
+	start := time.Now()
+	version, snapshot, err := g.resource.Snapshot()
 	if err != nil {
+		log.Printf("error: snapshot consistent error: %s", err.Error())
 		return err
 	}
-	w.resource.updateRoute(version, route)
+
+	if g.published != nil && g.published.version() == version {
+		log.Printf("info: xds %s snapshot version: %s unchanged, skip push", g.name, version)
+		return nil
+	}
+
+	log.Printf("info: xds %s snapshot version: %s", g.name, version)
+	if w.opt.ads {
+		w.publishAdsStages(g, snapshot)
+	} else {
+		w.cache.SetSnapshot(w.ctx, g.name, snapshot)
+	}
+	g.publish(g.resource.state())
+	h := g.resource.recordHistory(source, g.published, g.publishCfg, checksums)
+	log.Printf("info: xds %s history #%d(%s) changes: %v", g.name, h.Id, source, h.Changes)
+
+	if w.opt.stateDir != "" {
+		if err := saveSnapshot(w.opt.stateDir, g.name, g.published, g.config); err != nil {
+			log.Printf("warn: xds %s failed to persist snapshot: %s", g.name, err.Error())
+		}
+	}
+
+	metricSnapshotPushSeconds.WithLabelValues(g.name).Observe(time.Since(start).Seconds())
+	observeSnapshot(g.name, version)
 	return nil
 }
 
-func (w *WatchFile) updateLds(config LDSConfig) error {
-	version, listener, err := w.lds.create(config)
-	if err != nil {
+// rollbackNack restores the last-known-good snapshot of group,
+// if the rejected version of typeURL is still published. a pinned group is kept as is
+func (w *WatchFile) rollbackNack(groupName string, typeURL string, version string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	g, ok := w.findGroup(groupName)
+	if ok != true {
+		return fmt.Errorf("node group '%s' not found", groupName)
+	}
+	version = strings.TrimPrefix(version, adsWarmingVersionPrefix)
+	if g.pinned != 0 {
+		log.Printf("warn: xds %s %s version %s is NACKed but pinned to history #%d, skip rollback", g.name, typeName(typeURL), version, g.pinned)
+		return nil
+	}
+	if g.published == nil || g.published.typeVersion(typeURL) != version {
+		log.Printf("debug: xds %s %s version %s is not published, skip rollback", g.name, typeName(typeURL), version)
+		return nil
+	}
+	if g.lastGood == nil {
+		return fmt.Errorf("xds %s has no last-known-good snapshot to rollback from %s version %s", g.name, typeName(typeURL), version)
+	}
+
+	rejected, rejectedCfg := g.published, g.publishCfg
+	lastGood, lastGoodCfg := g.lastGood, g.lastGoodCfg
+	checksums := map[string]string{}
+	if h, ok := g.resource.findHistoryVersion(lastGood.version()); ok {
+		checksums = h.entry.Checksums
+	}
+	g.resource.restore(lastGood)
+	*g.config = lastGoodCfg
+	if err := w.applySnapshot(g, HistorySourceNackRollback, checksums); err != nil {
+		g.resource.restore(rejected)
+		*g.config = rejectedCfg
 		return err
 	}
-	w.resource.updateListener(version, listener)
+	// rejected version must not be a rollback target
+	g.lastGood = nil
+
+	log.Printf("warn: xds %s rollback snapshot version: %s -> %s (config files are kept as is, fix and save them to retry)", g.name, rejected.version(), lastGood.version())
 	return nil
 }
 
-func (w *WatchFile) updateSnapshot() error {
-	version, snapshot, err := w.resource.Snapshot()
-	if err != nil {
-		log.Printf("error: snapshot consistent error: %s", err.Error())
-		return err
+// publishAdsStages publishes snapshot in make-before-break stages(warmingStages), each stage waits
+// adsWarmingInterval on a timer without holding the lock, the next apply replaces the pending stages
+func (w *WatchFile) publishAdsStages(g *nodeGroup, snapshot *cachev3.Snapshot) {
+	g.cancelAdsStages()
+
+	stages := []*cachev3.Snapshot{snapshot}
+	if current, err := w.cache.GetSnapshot(g.name); err == nil {
+		stages = append(warmingStages(current, snapshot), snapshot)
+	}
+	w.publishAdsStage(g, g.adsSeq, stages)
+}
+
+func (w *WatchFile) publishAdsStage(g *nodeGroup, seq uint64, stages []*cachev3.Snapshot) {
+	if 1 < len(stages) {
+		log.Printf("info: xds %s warming snapshot version: %s", g.name, stages[0].GetVersion(resourcev3.ClusterType))
 	}
+	w.cache.SetSnapshot(w.ctx, g.name, stages[0])
+	if len(stages) < 2 {
+		return
+	}
+
+	g.adsTimer = time.AfterFunc(w.opt.adsWarmingInterval, func() {
+		w.mutex.Lock()
+		defer w.mutex.Unlock()
 
-	log.Printf("info: xds %s snapshot version: %s", w.nodeId, version)
-	w.cache.SetSnapshot(w.ctx, w.nodeId, snapshot)
+		if g.adsSeq != seq || w.ctx.Err() != nil {
+			return // replaced by the next apply
+		}
+		w.publishAdsStage(g, seq, stages[1:])
+	})
+}
+
+// InitialLoad loads all files, a node group whose files are broken is served
+// from the snapshot persisted in the state directory and keeps watching for a fix
+func (w *WatchFile) InitialLoad() error {
+	// hashed before reading, a change made while loading is found by the first scan of Watch
+	loaded := pathChecksums(w.watchPaths())
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.loaded = loaded
+	for _, g := range w.groups {
+		err := w.reloadGroup(g, g.types())
+		if err == nil {
+			continue
+		}
+		if w.opt.stateDir == "" {
+			return err
+		}
+
+		log.Printf("error: load %s file(s) error: %s, restore persisted snapshot", g.name, err.Error())
+		if restoreErr := w.restoreGroup(g); restoreErr != nil {
+			log.Printf("error: restore %s snapshot from %s error: %s", g.name, w.opt.stateDir, restoreErr.Error())
+			return err
+		}
+	}
 	return nil
 }
 
-// all or nothing reload
-func (w *WatchFile) ReloadAll() error {
-	cdsConfig, err := w.loadCds()
+func (w *WatchFile) restoreGroup(g *nodeGroup) error {
+	p, err := loadSnapshot(w.opt.stateDir, g.name)
 	if err != nil {
 		return err
 	}
-	edsConfig, err := w.loadEds()
+	state, err := p.Resources.state()
 	if err != nil {
 		return err
 	}
-	rdsConfig, err := w.loadRds()
-	if err != nil {
+
+	config := p.Config.config()
+	g.resource.restore(state)
+	g.config = config
+	g.secretFiles = secretFiles(config.sds)
+	if err := w.applySnapshot(g, HistorySourceStateDir, map[string]string{}); err != nil {
 		return err
 	}
-	ldsConfig, err := w.loadLds()
-	if err != nil {
-		return err
+	log.Printf("warn: xds %s serving persisted snapshot version %s saved at %s", g.name, p.Version, p.SavedAt.Format(time.RFC3339))
+	return nil
+}
+
+// ReloadAll reloads all node groups all or nothing, the candidates of every group are built first
+// and none of them is published if any is broken, the groups already published are reverted
+// if a later one fails to publish. pinned node groups are skipped
+func (w *WatchFile) ReloadAll() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	type candidate struct {
+		group    *nodeGroup
+		resource *resource
+		config   nodeGroupConfig
+	}
+	candidates := make([]candidate, 0, len(w.groups))
+	errs := make(ValidationErrors, 0)
+	for _, g := range w.groups {
+		if g.pinned != 0 {
+			log.Printf("info: xds %s is pinned to history #%d, skip reload", g.name, g.pinned)
+			continue
+		}
+		r, config, groupErrs := w.buildCandidate(g)
+		if 0 < len(groupErrs) {
+			g.setErrorStatus(groupErrs)
+			g.observeReload(g.types(), groupErrs)
+			errs = append(errs, groupErrs...)
+			continue
+		}
+		candidates = append(candidates, candidate{g, r, config})
+	}
+	if 0 < len(errs) {
+		return errs
+	}
+
+	committed := make([]groupState, 0, len(candidates))
+	for _, c := range candidates {
+		prev := newGroupState(c.group)
+		if err := w.commitGroup(c.group, c.resource, c.config); err != nil {
+			c.group.setAllFileStatus(err)
+			c.group.observeReload(c.group.types(), err)
+			// groups committed so far are reverted, their files are kept as is
+			for _, p := range committed {
+				if revertErr := w.revertGroup(p); revertErr != nil {
+					log.Printf("error: xds %s failed to revert: %s", p.group.name, revertErr.Error())
+				}
+			}
+			return err
+		}
+		committed = append(committed, prev)
+	}
+	for _, c := range candidates {
+		c.group.setAllFileStatus(nil)
+		c.group.observeReload(c.group.types(), nil)
 	}
+	return nil
+}
 
-	if err := w.updateCds(cdsConfig); err != nil {
-		return err
+// groupState is the resources and the published snapshot of a node group before ReloadAll commits it
+type groupState struct {
+	group      *nodeGroup
+	current    *resourceState
+	config     nodeGroupConfig
+	published  *resourceState
+	publishCfg nodeGroupConfig
+}
+
+func newGroupState(g *nodeGroup) groupState {
+	return groupState{
+		group:      g,
+		current:    g.resource.state(),
+		config:     *g.config,
+		published:  g.published,
+		publishCfg: g.publishCfg,
 	}
-	if err := w.updateEds(edsConfig); err != nil {
-		return err
+}
+
+// revertGroup publishes the previous snapshot of s.group again, after a later group of ReloadAll failed to publish.
+// the snapshot is cleared if nothing was published before
+func (w *WatchFile) revertGroup(s groupState) error {
+	g := s.group
+	if s.published == nil {
+		g.cancelAdsStages()
+		w.cache.ClearSnapshot(g.name)
+		g.resource.restore(s.current)
+		*g.config = s.config
+		g.secretFiles = secretFiles(s.config.sds)
+		g.published = nil
+		g.publishCfg = nodeGroupConfig{}
+		g.lastGood = nil
+		g.lastGoodCfg = nodeGroupConfig{}
+		log.Printf("warn: xds %s snapshot cleared, reload of another node group failed", g.name)
+		return nil
+	}
+
+	checksums := map[string]string{}
+	if h, ok := g.resource.findHistoryVersion(s.published.version()); ok {
+		checksums = h.entry.Checksums
+	}
+	g.resource.restore(s.published)
+	*g.config = s.publishCfg
+	g.secretFiles = secretFiles(s.publishCfg.sds)
+	if err := w.applySnapshot(g, HistorySourceRollback, checksums); err != nil {
+		return err
+	}
+	log.Printf("warn: xds %s reverted to snapshot version %s, reload of another node group failed", g.name, s.published.version())
+	return nil
+}
+
+// reloadGroup stages all files of g, builds the candidate snapshot and publishes it only if it is consistent,
+// the current resources are kept as is on error. types are the changed xDS types counted as reload attempts
+func (w *WatchFile) reloadGroup(g *nodeGroup, types []string) error {
+	r, config, errs := w.buildCandidate(g)
+	if 0 < len(errs) {
+		g.setErrorStatus(errs)
+		g.observeReload(types, errs)
+		return errs
 	}
-	if err := w.updateRds(rdsConfig); err != nil {
+
+	err := w.commitGroup(g, r, config)
+	g.setAllFileStatus(err)
+	g.observeReload(types, err)
+	return err
+}
+
+// commitGroup replaces the resources of g with the candidate and publishes them,
+// the previous resources are restored if publishing fails
+func (w *WatchFile) commitGroup(g *nodeGroup, candidate *resource, config nodeGroupConfig) error {
+	current, currentCfg := g.resource.state(), *g.config
+	g.resource.restore(candidate.state())
+	*g.config = config
+	g.secretFiles = secretFiles(config.sds)
+	if err := w.updateSnapshot(g); err != nil {
+		g.resource.restore(current)
+		*g.config = currentCfg
+		g.secretFiles = secretFiles(currentCfg.sds)
 		return err
 	}
-	if err := w.updateLds(ldsConfig); err != nil {
+	return nil
+}
+
+// History returns the applied snapshots of each node group
+func (w *WatchFile) History() []*NodeGroupHistory {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	list := make([]*NodeGroupHistory, 0, len(w.groups))
+	for _, g := range w.groups {
+		h := &NodeGroupHistory{
+			NodeGroup: g.name,
+			Pinned:    g.pinned,
+			Current:   "",
+			Entries:   make([]*HistoryEntry, 0),
+		}
+		if g.published != nil {
+			h.Current = g.published.version()
+		}
+		for _, e := range g.resource.historyEntries() {
+			h.Entries = append(h.Entries, e.entry)
+		}
+		list = append(list, h)
+	}
+	return list
+}
+
+// RollbackHistory applies the history entry id of group, the next file change is applied as usual
+func (w *WatchFile) RollbackHistory(groupName string, id uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.applyHistory(groupName, id, false)
+}
+
+// PinHistory applies the history entry id of group and ignores file changes until Unpin
+func (w *WatchFile) PinHistory(groupName string, id uint64) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.applyHistory(groupName, id, true)
+}
+
+// Unpin reloads the files of group and goes back to file tracking
+func (w *WatchFile) Unpin(groupName string) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	g, ok := w.findGroup(groupName)
+	if ok != true {
+		return fmt.Errorf("node group '%s' not found", groupName)
+	}
+	if g.pinned == 0 {
+		return fmt.Errorf("node group '%s' is not pinned", groupName)
+	}
+
+	pinned := g.pinned
+	g.pinned = 0
+	if err := w.reloadGroup(g, g.types()); err != nil {
+		g.pinned = pinned
 		return err
 	}
+	log.Printf("info: xds %s unpinned from history #%d, tracking files", g.name, pinned)
+	return nil
+}
 
-	if err := w.updateSnapshot(); err != nil {
+func (w *WatchFile) applyHistory(groupName string, id uint64, pin bool) error {
+	g, ok := w.findGroup(groupName)
+	if ok != true {
+		return fmt.Errorf("node group '%s' not found", groupName)
+	}
+	h, ok := g.resource.findHistory(id)
+	if ok != true {
+		return fmt.Errorf("history #%d of node group '%s' not found", id, groupName)
+	}
+
+	source := HistorySourceRollback
+	if pin {
+		source = HistorySourcePin
+	}
+
+	current, currentCfg := g.resource.state(), *g.config
+	g.resource.restore(h.state)
+	*g.config = h.config
+	g.secretFiles = secretFiles(h.config.sds)
+	if err := w.applySnapshot(g, source, h.entry.Checksums); err != nil {
+		g.resource.restore(current)
+		*g.config = currentCfg
+		g.secretFiles = secretFiles(currentCfg.sds)
 		return err
 	}
+
+	if pin {
+		g.pinned = id
+		log.Printf("warn: xds %s pinned to history #%d version %s, file changes are ignored until unpin", g.name, id, h.entry.Version)
+	} else {
+		g.pinned = 0
+		log.Printf("warn: xds %s rollback to history #%d version %s", g.name, id, h.entry.Version)
+	}
 	return nil
 }
 
-func NewWatchFile(ctx context.Context, nodeId string, funcs ...watchOptFunc) *WatchFile {
+// SnapshotStates returns the current resources of each node group
+func (w *WatchFile) SnapshotStates() ([]*SnapshotState, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	states := make([]*SnapshotState, 0, len(w.groups))
+	for _, g := range w.groups {
+		state, err := newSnapshotState(g.name, g.resource.state())
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// ConfigStates returns the parsed config files and the last reload result of each node group
+func (w *WatchFile) ConfigStates() []*ConfigState {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	states := make([]*ConfigState, 0, len(w.groups))
+	for _, g := range w.groups {
+		states = append(states, g.configState())
+	}
+	return states
+}
+
+func NewWatchFile(ctx context.Context, funcs ...watchOptFunc) *WatchFile {
 	opt := new(watchOpt)
 	for _, fn := range funcs {
 		fn(opt)
 	}
-
-	xdsConfig := xdsConfigSource()
-	return &WatchFile{
-		ctx:      ctx,
-		nodeId:   nodeId,
-		opt:      opt,
-		cache:    cachev3.NewSnapshotCache(false, cachev3.IDHash{}, newLoggerSnapshotCache()),
-		cds:      newClusterDiscoveryService(xdsConfig),
-		eds:      newEndpointDiscoveryService(xdsConfig),
-		rds:      newRouteDiscoveryService(xdsConfig),
-		lds:      newListenerDiscoveryService(xdsConfig),
-		resource: newResource(),
-	}
+	initWatchOpt(opt)
+
+	groups := make([]*nodeGroup, 0, len(opt.nodeGroups)+1)
+	for _, g := range opt.nodeGroups {
+		groups = append(groups, newNodeGroupFromDir(g.Name, g.Dir))
+	}
+	// unknown nodes fallback to default group
+	groups = append(groups, newNodeGroup(DefaultNodeGroupName, opt.cdsYaml, opt.edsYaml, opt.rdsYaml, opt.ldsYaml, opt.sdsYaml, opt.runtimeYaml))
+	for _, g := range groups {
+		g.resource.setHistorySize(opt.historySize)
+	}
+
+	hash := newNodeGroupHash(opt.nodeGroups, DefaultNodeGroupName)
+	xdsConfig := xdsConfigSource(opt.ads, opt.delta)
+	w := &WatchFile{
+		ctx:    ctx,
+		mutex:  new(sync.Mutex),
+		opt:    opt,
+		cache:  cachev3.NewSnapshotCache(opt.ads, hash, newLoggerSnapshotCache()),
+		cds:    newClusterDiscoveryService(xdsConfig),
+		eds:    newEndpointDiscoveryService(xdsConfig),
+		rds:    newRouteDiscoveryService(xdsConfig),
+		lds:    newListenerDiscoveryService(xdsConfig),
+		sds:    newSecretDiscoveryService(),
+		rtds:   newRuntimeDiscoveryService(xdsConfig),
+		hash:   hash,
+		groups: groups,
+	}
+	w.watcher = newFileWatcher("config", fileWatchOpt{
+		polling:      opt.watchPolling,
+		pollInterval: opt.watchPollInterval,
+		debounce:     opt.watchDebounce,
+		observeLost:  observeWatchLost,
+	}, w.watchPaths, w.changeFiles)
+	return w
 }
 
 func equalPath(src, target string) bool {
@@ -408,3 +948,18 @@ func equalPath(src, target string) bool {
 	}
 	return absSrc == absTarget
 }
+
+func secretFiles(configs []SDSConfig) []string {
+	files := make([]string, 0, len(configs)*2)
+	for _, c := range configs {
+		files = append(files, c.Files()...)
+	}
+	return files
+}
+
+func joinRelPath(baseDir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
@@ -0,0 +1,126 @@
+package xds
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+func TestRevertGroup(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for _, name := range []string{NodeGroupCdsFileName, NodeGroupEdsFileName, NodeGroupRdsFileName, NodeGroupLdsFileName} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %s", name, err.Error())
+		}
+		files[name] = string(data)
+	}
+	testWriteFiles(t, dir, files)
+
+	w := NewWatchFile(context.Background(),
+		WatchCdsConfigFile(filepath.Join(dir, NodeGroupCdsFileName)),
+		WatchEdsConfigFile(filepath.Join(dir, NodeGroupEdsFileName)),
+		WatchRdsConfigFile(filepath.Join(dir, NodeGroupRdsFileName)),
+		WatchLdsConfigFile(filepath.Join(dir, NodeGroupLdsFileName)),
+	)
+	g, _ := w.findGroup(DefaultNodeGroupName)
+
+	t.Run("nothing published", func(tt *testing.T) {
+		prev := newGroupState(g)
+		if err := w.reloadGroup(g, g.types()); err != nil {
+			tt.Fatalf("reload: %s", err.Error())
+		}
+		if err := w.revertGroup(prev); err != nil {
+			tt.Fatalf("revert: %s", err.Error())
+		}
+		if _, err := w.cache.GetSnapshot(g.name); err == nil {
+			tt.Errorf("snapshot must be cleared")
+		}
+		if g.published != nil {
+			tt.Errorf("published must be reset: %s", g.published.version())
+		}
+	})
+	t.Run("published", func(tt *testing.T) {
+		if err := w.reloadGroup(g, g.types()); err != nil {
+			tt.Fatalf("reload: %s", err.Error())
+		}
+		prev := newGroupState(g)
+		prevVersion := g.published.version()
+		clusterVersion := g.published.typeVersion(resourcev3.ClusterType)
+
+		testWriteFiles(tt, dir, map[string]string{
+			NodeGroupCdsFileName: strings.Replace(files[NodeGroupCdsFileName], "least-request", "random", -1),
+		})
+		if err := w.reloadGroup(g, g.types()); err != nil {
+			tt.Fatalf("reload: %s", err.Error())
+		}
+		if err := w.revertGroup(prev); err != nil {
+			tt.Fatalf("revert: %s", err.Error())
+		}
+		if g.published.version() != prevVersion {
+			tt.Errorf("expect version %s actual %s", prevVersion, g.published.version())
+		}
+		snapshot, err := w.cache.GetSnapshot(g.name)
+		if err != nil {
+			tt.Fatalf("snapshot: %s", err.Error())
+		}
+		if v := snapshot.GetVersion(resourcev3.ClusterType); v != clusterVersion {
+			tt.Errorf("expect cluster version %s actual %s", clusterVersion, v)
+		}
+		for _, st := range g.fileStatus {
+			if st.Status != FileStatusOk {
+				tt.Errorf("%s status must be kept: %s %s", st.Type, st.Status, st.LastError)
+			}
+		}
+	})
+}
+
+// TestWatchAfterInitialLoad checks that a file changed between InitialLoad and Watch is reloaded by the first scan
+func TestWatchAfterInitialLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{}
+	for _, name := range []string{NodeGroupCdsFileName, NodeGroupEdsFileName, NodeGroupRdsFileName, NodeGroupLdsFileName} {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("read %s: %s", name, err.Error())
+		}
+		files[name] = string(data)
+	}
+	testWriteFiles(t, dir, files)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := NewWatchFile(ctx,
+		WatchCdsConfigFile(filepath.Join(dir, NodeGroupCdsFileName)),
+		WatchEdsConfigFile(filepath.Join(dir, NodeGroupEdsFileName)),
+		WatchRdsConfigFile(filepath.Join(dir, NodeGroupRdsFileName)),
+		WatchLdsConfigFile(filepath.Join(dir, NodeGroupLdsFileName)),
+	)
+	if err := w.InitialLoad(); err != nil {
+		t.Fatalf("initial load: %s", err.Error())
+	}
+	version := func() string {
+		snapshot, err := w.cache.GetSnapshot(DefaultNodeGroupName)
+		if err != nil {
+			t.Fatalf("snapshot: %s", err.Error())
+		}
+		return snapshot.GetVersion(resourcev3.EndpointType)
+	}
+	prev := version()
+
+	testWriteFiles(t, dir, map[string]string{
+		NodeGroupEdsFileName: strings.Replace(files[NodeGroupEdsFileName], "10.10.1.101", "10.10.1.201", 1),
+	})
+	if err := w.Watch(ctx); err != nil {
+		t.Fatalf("watch: %s", err.Error())
+	}
+	testEventually(t, func() bool {
+		return version() != prev
+	})
+}
@@ -11,25 +11,47 @@ import (
 )
 
 const (
-	BootstrapXdsClusterName string        = "xds_cluster"
-	BootstrapAlsClusterName string        = "als_cluster"
-	EnvoyRESTRefreshDelay   time.Duration = 10 * time.Second
-	EnvoyRESTRequestTimeout time.Duration = 10 * time.Second
-	EnvoyGRPCRequestTimeout time.Duration = 10 * time.Second
+	BootstrapXdsClusterName   string        = "xds_cluster"
+	BootstrapAlsClusterName   string        = "als_cluster"
+	BootstrapRuntimeLayerName string        = "runtime0"
+	EnvoyRESTRefreshDelay     time.Duration = 10 * time.Second
+	EnvoyRESTRequestTimeout   time.Duration = 10 * time.Second
+	EnvoyGRPCRequestTimeout   time.Duration = 10 * time.Second
 )
 
 func xdsName(values ...string) string {
 	return strings.ReplaceAll(strings.Join(values, "_"), "-", "_")
 }
 
-func xdsConfigSource() *corev3.ConfigSource {
+func xdsConfigSource(ads bool, delta bool) *corev3.ConfigSource {
+	if ads {
+		// delta or sotw is negotiated by ads_config in bootstrap
+		return xdsAdsConfigSource()
+	}
+	if delta {
+		return xdsApiConfigSource(corev3.ApiConfigSource_DELTA_GRPC)
+	}
+	return xdsApiConfigSource(corev3.ApiConfigSource_GRPC)
+}
+
+func xdsAdsConfigSource() *corev3.ConfigSource {
+	// https://www.envoyproxy.io/docs/envoy/v1.28.0/api-docs/xds_protocol#aggregated-discovery-service
+	return &corev3.ConfigSource{
+		ResourceApiVersion: resourcev3.DefaultAPIVersion,
+		ConfigSourceSpecifier: &corev3.ConfigSource_Ads{
+			Ads: &corev3.AggregatedConfigSource{},
+		},
+	}
+}
+
+func xdsApiConfigSource(apiType corev3.ApiConfigSource_ApiType) *corev3.ConfigSource {
 	return &corev3.ConfigSource{
 		ResourceApiVersion: resourcev3.DefaultAPIVersion,
 		ConfigSourceSpecifier: &corev3.ConfigSource_ApiConfigSource{
 			// https://www.envoyproxy.io/docs/envoy/v1.15.0/api-v3/config/core/v3/config_source.proto.html
 			ApiConfigSource: &corev3.ApiConfigSource{
 				TransportApiVersion:       resourcev3.DefaultAPIVersion,
-				ApiType:                   corev3.ApiConfigSource_GRPC,
+				ApiType:                   apiType,
 				SetNodeOnFirstMessageOnly: true,
 				RefreshDelay:              ptypes.DurationProto(EnvoyRESTRefreshDelay),
 				RequestTimeout:            ptypes.DurationProto(EnvoyRESTRequestTimeout),
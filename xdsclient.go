@@ -0,0 +1,567 @@
+package xds
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	clusterservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
+	discoverygrpcv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	listenerservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/listener/v3"
+	routeservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/route/v3"
+	runtimeservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/runtime/v3"
+	secretservicev3 "github.com/envoyproxy/go-control-plane/envoy/service/secret/v3"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+)
+
+const (
+	defaultXdsClientAddr    string = "127.0.0.1:8000"
+	defaultXdsClientNodeId  string = "xds-client"
+	defaultXdsClientCluster string = "xds-client"
+	xdsClientNackMessage    string = "rejected by xds-client"
+)
+
+var (
+	// XdsClientTypes are the types subscribed by default, the same as envoy with dynamic_resources
+	XdsClientTypes = []string{"CDS", "EDS", "RDS", "LDS"}
+)
+
+type xdsClientOptFunc func(*xdsClientOpt)
+
+type xdsClientOpt struct {
+	addr          string
+	nodeId        string
+	cluster       string
+	region        string
+	zone          string
+	metadata      map[string]string
+	ads           bool
+	delta         bool
+	tlsCAFile     string
+	tlsCertFile   string
+	tlsKeyFile    string
+	tlsSNI        string
+	subscriptions map[string][]string // typeURL -> resource names(empty is wildcard)
+	nackTypes     map[string]struct{}
+}
+
+// XdsClientAddr is the host:port of the xds server
+func XdsClientAddr(addr string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.addr = addr
+	}
+}
+
+func XdsClientNode(nodeId, cluster string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.nodeId = nodeId
+		opt.cluster = cluster
+	}
+}
+
+func XdsClientLocality(region, zone string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.region = region
+		opt.zone = zone
+	}
+}
+
+// XdsClientMetadata is the node metadata, values are sent as string
+func XdsClientMetadata(metadata map[string]string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.metadata = metadata
+	}
+}
+
+// XdsClientADS subscribes all types over a single ADS stream
+func XdsClientADS(enable bool) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.ads = enable
+	}
+}
+
+// XdsClientDelta subscribes with incremental xDS instead of SotW
+func XdsClientDelta(enable bool) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.delta = enable
+	}
+}
+
+// XdsClientTLS connects with TLS, caFile verifies the server and certFile/keyFile is the client certificate(mTLS)
+func XdsClientTLS(caFile, certFile, keyFile, sni string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.tlsCAFile = caFile
+		opt.tlsCertFile = certFile
+		opt.tlsKeyFile = keyFile
+		opt.tlsSNI = sni
+	}
+}
+
+// XdsClientSubscriptions subscribes typeName(CDS, EDS, RDS, LDS, SDS, RTDS) -> resource names,
+// all resources of the type if names are empty
+func XdsClientSubscriptions(subscriptions map[string][]string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.subscriptions = make(map[string][]string, len(subscriptions))
+		for typeName, names := range subscriptions {
+			typeURL, _ := XdsTypeURL(typeName)
+			opt.subscriptions[typeURL] = append(opt.subscriptions[typeURL], names...)
+		}
+	}
+}
+
+// XdsClientNack rejects(NACK) every response of typeNames instead of ACK
+func XdsClientNack(typeNames ...string) xdsClientOptFunc {
+	return func(opt *xdsClientOpt) {
+		opt.nackTypes = make(map[string]struct{}, len(typeNames))
+		for _, typeName := range typeNames {
+			typeURL, _ := XdsTypeURL(typeName)
+			opt.nackTypes[typeURL] = struct{}{}
+		}
+	}
+}
+
+func initXdsClientOpt(opt *xdsClientOpt) {
+	if opt.addr == "" {
+		opt.addr = defaultXdsClientAddr
+	}
+	if opt.nodeId == "" {
+		opt.nodeId = defaultXdsClientNodeId
+	}
+	if opt.cluster == "" {
+		opt.cluster = defaultXdsClientCluster
+	}
+	if len(opt.subscriptions) < 1 {
+		opt.subscriptions = make(map[string][]string, len(XdsClientTypes))
+		for _, typeName := range XdsClientTypes {
+			typeURL, _ := XdsTypeURL(typeName)
+			opt.subscriptions[typeURL] = nil
+		}
+	}
+	if opt.nackTypes == nil {
+		opt.nackTypes = make(map[string]struct{})
+	}
+}
+
+// XdsTypeURL returns the type url of CDS, EDS, RDS, LDS, SDS or RTDS(case insensitive)
+func XdsTypeURL(typeName string) (string, bool) {
+	switch strings.ToUpper(typeName) {
+	case "CDS":
+		return resourcev3.ClusterType, true
+	case "EDS":
+		return resourcev3.EndpointType, true
+	case "RDS":
+		return resourcev3.RouteType, true
+	case "LDS":
+		return resourcev3.ListenerType, true
+	case "SDS":
+		return resourcev3.SecretType, true
+	case "RTDS":
+		return resourcev3.RuntimeType, true
+	default:
+		return typeName, false
+	}
+}
+
+// XdsResponse is a response received by XdsClient
+type XdsResponse struct {
+	Type      string         `json:"type"`
+	Version   string         `json:"version"`
+	Nonce     string         `json:"nonce"`
+	Resources []*XdsResource `json:"resources"`
+	Removed   []string       `json:"removed,omitempty"` // delta only
+	Nack      bool           `json:"nack"`
+}
+
+type XdsResource struct {
+	Name     string          `json:"name"`
+	Version  string          `json:"version,omitempty"` // delta only
+	Resource json.RawMessage `json:"resource"`
+}
+
+// XdsClientHandler is called for each response after ACK/NACK is sent, returns false to stop
+type XdsClientHandler func(*XdsResponse) bool
+
+// sotwStream is the SotW stream of each discovery service
+type sotwStream interface {
+	Send(*discoverygrpcv3.DiscoveryRequest) error
+	Recv() (*discoverygrpcv3.DiscoveryResponse, error)
+}
+
+// deltaStream is the incremental stream of each discovery service
+type deltaStream interface {
+	Send(*discoverygrpcv3.DeltaDiscoveryRequest) error
+	Recv() (*discoverygrpcv3.DeltaDiscoveryResponse, error)
+}
+
+// XdsClient subscribes resources like an envoy node, to debug or check the xds server
+type XdsClient struct {
+	opt     *xdsClientOpt
+	node    *corev3.Node
+	mutex   *sync.Mutex
+	handler XdsClientHandler
+}
+
+// Run subscribes until handler returns false or ctx is done(nil), or a stream fails
+func (c *XdsClient) Run(ctx context.Context, handler XdsClientHandler) error {
+	creds, err := c.credentials()
+	if err != nil {
+		return err
+	}
+	conn, err := grpc.DialContext(ctx, c.opt.addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	c.handler = handler
+
+	typeURLs := make([]string, 0, len(c.opt.subscriptions))
+	for _, typeName := range []string{"CDS", "EDS", "RDS", "LDS", "SDS", "RTDS"} {
+		typeURL, _ := XdsTypeURL(typeName)
+		if _, ok := c.opt.subscriptions[typeURL]; ok {
+			typeURLs = append(typeURLs, typeURL)
+		}
+	}
+
+	if c.opt.ads {
+		return c.subscribe(ctx, conn, "", typeURLs)
+	}
+
+	wg := new(sync.WaitGroup)
+	errs := make(chan error, len(typeURLs))
+	for _, typeURL := range typeURLs {
+		wg.Add(1)
+		go func(typeURL string) {
+			defer wg.Done()
+			if err := c.subscribe(ctx, conn, typeURL, []string{typeURL}); err != nil {
+				errs <- err
+			}
+			cancel() // stops the other streams
+		}(typeURL)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// subscribe opens a stream of serviceType(ADS if empty) and subscribes typeURLs
+func (c *XdsClient) subscribe(ctx context.Context, conn *grpc.ClientConn, serviceType string, typeURLs []string) error {
+	if c.opt.delta {
+		stream, err := openDeltaStream(ctx, conn, serviceType)
+		if err != nil {
+			return err
+		}
+		return c.runDelta(ctx, stream, typeURLs)
+	}
+	stream, err := openSotwStream(ctx, conn, serviceType)
+	if err != nil {
+		return err
+	}
+	return c.runSotw(ctx, stream, typeURLs)
+}
+
+func (c *XdsClient) runSotw(ctx context.Context, stream sotwStream, typeURLs []string) error {
+	acked := make(map[string]string, len(typeURLs)) // typeURL -> last ACKed version
+	for _, typeURL := range typeURLs {
+		req := &discoverygrpcv3.DiscoveryRequest{
+			Node:          c.node,
+			TypeUrl:       typeURL,
+			ResourceNames: c.opt.subscriptions[typeURL],
+		}
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return streamError(ctx, err)
+		}
+
+		_, nack := c.opt.nackTypes[res.GetTypeUrl()]
+		req := &discoverygrpcv3.DiscoveryRequest{
+			Node:          c.node,
+			TypeUrl:       res.GetTypeUrl(),
+			ResourceNames: c.opt.subscriptions[res.GetTypeUrl()],
+			ResponseNonce: res.GetNonce(),
+			VersionInfo:   res.GetVersionInfo(),
+		}
+		if nack {
+			req.VersionInfo = acked[res.GetTypeUrl()]
+			req.ErrorDetail = nackStatus()
+		} else {
+			acked[res.GetTypeUrl()] = res.GetVersionInfo()
+		}
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+
+		resources, err := decodeResources(res.GetResources())
+		if err != nil {
+			return err
+		}
+		next := c.handle(&XdsResponse{
+			Type:      typeName(res.GetTypeUrl()),
+			Version:   res.GetVersionInfo(),
+			Nonce:     res.GetNonce(),
+			Resources: resources,
+			Nack:      nack,
+		})
+		if next != true {
+			return nil
+		}
+	}
+}
+
+func (c *XdsClient) runDelta(ctx context.Context, stream deltaStream, typeURLs []string) error {
+	for _, typeURL := range typeURLs {
+		req := &discoverygrpcv3.DeltaDiscoveryRequest{
+			Node:                   c.node,
+			TypeUrl:                typeURL,
+			ResourceNamesSubscribe: c.opt.subscriptions[typeURL],
+		}
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return streamError(ctx, err)
+		}
+
+		_, nack := c.opt.nackTypes[res.GetTypeUrl()]
+		req := &discoverygrpcv3.DeltaDiscoveryRequest{
+			Node:          c.node,
+			TypeUrl:       res.GetTypeUrl(),
+			ResponseNonce: res.GetNonce(),
+		}
+		if nack {
+			req.ErrorDetail = nackStatus()
+		}
+		if err := stream.Send(req); err != nil {
+			return err
+		}
+
+		resources := make([]*XdsResource, len(res.GetResources()))
+		for i, r := range res.GetResources() {
+			resource, err := decodeResource(r.GetResource())
+			if err != nil {
+				return err
+			}
+			resource.Name = r.GetName()
+			resource.Version = r.GetVersion()
+			resources[i] = resource
+		}
+		next := c.handle(&XdsResponse{
+			Type:      typeName(res.GetTypeUrl()),
+			Version:   res.GetSystemVersionInfo(),
+			Nonce:     res.GetNonce(),
+			Resources: resources,
+			Removed:   res.GetRemovedResources(),
+			Nack:      nack,
+		})
+		if next != true {
+			return nil
+		}
+	}
+}
+
+// handle serializes the handler calls of the streams
+func (c *XdsClient) handle(res *XdsResponse) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.handler(res)
+}
+
+func (c *XdsClient) credentials() (credentials.TransportCredentials, error) {
+	if c.opt.tlsCAFile == "" && c.opt.tlsCertFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+
+	config := &tls.Config{ServerName: c.opt.tlsSNI}
+	if c.opt.tlsCAFile != "" {
+		data, err := ioutil.ReadFile(c.opt.tlsCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if pool.AppendCertsFromPEM(data) != true {
+			return nil, fmt.Errorf("no certificate found in %s", c.opt.tlsCAFile)
+		}
+		config.RootCAs = pool
+	}
+	if c.opt.tlsCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.opt.tlsCertFile, c.opt.tlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(config), nil
+}
+
+func openSotwStream(ctx context.Context, conn *grpc.ClientConn, serviceType string) (sotwStream, error) {
+	switch serviceType {
+	case "":
+		return discoverygrpcv3.NewAggregatedDiscoveryServiceClient(conn).StreamAggregatedResources(ctx)
+	case resourcev3.ClusterType:
+		return clusterservicev3.NewClusterDiscoveryServiceClient(conn).StreamClusters(ctx)
+	case resourcev3.EndpointType:
+		return endpointservicev3.NewEndpointDiscoveryServiceClient(conn).StreamEndpoints(ctx)
+	case resourcev3.RouteType:
+		return routeservicev3.NewRouteDiscoveryServiceClient(conn).StreamRoutes(ctx)
+	case resourcev3.ListenerType:
+		return listenerservicev3.NewListenerDiscoveryServiceClient(conn).StreamListeners(ctx)
+	case resourcev3.SecretType:
+		return secretservicev3.NewSecretDiscoveryServiceClient(conn).StreamSecrets(ctx)
+	case resourcev3.RuntimeType:
+		return runtimeservicev3.NewRuntimeDiscoveryServiceClient(conn).StreamRuntime(ctx)
+	}
+	return nil, fmt.Errorf("unknown type '%s'", serviceType)
+}
+
+func openDeltaStream(ctx context.Context, conn *grpc.ClientConn, serviceType string) (deltaStream, error) {
+	switch serviceType {
+	case "":
+		return discoverygrpcv3.NewAggregatedDiscoveryServiceClient(conn).DeltaAggregatedResources(ctx)
+	case resourcev3.ClusterType:
+		return clusterservicev3.NewClusterDiscoveryServiceClient(conn).DeltaClusters(ctx)
+	case resourcev3.EndpointType:
+		return endpointservicev3.NewEndpointDiscoveryServiceClient(conn).DeltaEndpoints(ctx)
+	case resourcev3.RouteType:
+		return routeservicev3.NewRouteDiscoveryServiceClient(conn).DeltaRoutes(ctx)
+	case resourcev3.ListenerType:
+		return listenerservicev3.NewListenerDiscoveryServiceClient(conn).DeltaListeners(ctx)
+	case resourcev3.SecretType:
+		return secretservicev3.NewSecretDiscoveryServiceClient(conn).DeltaSecrets(ctx)
+	case resourcev3.RuntimeType:
+		return runtimeservicev3.NewRuntimeDiscoveryServiceClient(conn).DeltaRuntime(ctx)
+	}
+	return nil, fmt.Errorf("unknown type '%s'", serviceType)
+}
+
+// streamError returns nil if the stream is closed by ctx
+func streamError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err == io.EOF {
+		return fmt.Errorf("stream closed by server")
+	}
+	return err
+}
+
+func nackStatus() *status.Status {
+	return &status.Status{
+		Code:    int32(codes.InvalidArgument),
+		Message: xdsClientNackMessage,
+	}
+}
+
+func decodeResources(resources []*anypb.Any) ([]*XdsResource, error) {
+	decoded := make([]*XdsResource, len(resources))
+	for i, r := range resources {
+		resource, err := decodeResource(r)
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = resource
+	}
+	return decoded, nil
+}
+
+func decodeResource(r *anypb.Any) (*XdsResource, error) {
+	msg, err := r.UnmarshalNew()
+	if err != nil {
+		return nil, err
+	}
+	data, err := protojson.MarshalOptions{UseProtoNames: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	return &XdsResource{
+		Name:     cachev3.GetResourceName(msg),
+		Resource: json.RawMessage(data),
+	}, nil
+}
+
+func xdsClientNode(opt *xdsClientOpt) (*corev3.Node, error) {
+	node := &corev3.Node{
+		Id:                   opt.nodeId,
+		Cluster:              opt.cluster,
+		UserAgentName:        AppName,
+		UserAgentVersionType: &corev3.Node_UserAgentVersion{UserAgentVersion: Version},
+	}
+	if opt.region != "" || opt.zone != "" {
+		node.Locality = &corev3.Locality{
+			Region: opt.region,
+			Zone:   opt.zone,
+		}
+	}
+	if 0 < len(opt.metadata) {
+		fields := make(map[string]interface{}, len(opt.metadata))
+		for k, v := range opt.metadata {
+			fields[k] = v
+		}
+		metadata, err := structpb.NewStruct(fields)
+		if err != nil {
+			return nil, err
+		}
+		node.Metadata = metadata
+	}
+	return node, nil
+}
+
+func NewXdsClient(funcs ...xdsClientOptFunc) (*XdsClient, error) {
+	opt := new(xdsClientOpt)
+	for _, fn := range funcs {
+		fn(opt)
+	}
+	initXdsClientOpt(opt)
+
+	for typeURL := range opt.subscriptions {
+		if _, ok := XdsTypeURL(typeName(typeURL)); ok != true {
+			return nil, fmt.Errorf("unknown type '%s'", typeURL)
+		}
+	}
+	for typeURL := range opt.nackTypes {
+		if _, ok := XdsTypeURL(typeName(typeURL)); ok != true {
+			return nil, fmt.Errorf("unknown type '%s'", typeURL)
+		}
+	}
+
+	node, err := xdsClientNode(opt)
+	if err != nil {
+		return nil, err
+	}
+	return &XdsClient{
+		opt:   opt,
+		node:  node,
+		mutex: new(sync.Mutex),
+	}, nil
+}